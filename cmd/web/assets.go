@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The staticAsset type describes a file in ui/static. Hashed is the name of the
+// file with a part of its hash (for example, css/main.1a2b3c4d5e6f.css), so that
+// browsers fetch the file again as soon as it changes. Integrity is the value
+// of the integrity attribute (subresource integrity) of the tags which load it.
+// ETag is the entity tag of the file, so that browsers can revalidate it by its
+// own name with If-None-Match. Data is set for the stylesheets, whose references
+// to the other static files are rewritten to the hashed names, and is served
+// instead of the file on disk.
+type staticAsset struct {
+	Name      string
+	Hashed    string
+	Integrity string
+	ETag      string
+	Data      []byte
+}
+
+// The cssURL regular expression matches the references to the static files in
+// the stylesheets, for example url("/static/fonts/x.woff2"). The first group is
+// the part before the name of the file, the second one is the name.
+var cssURL = regexp.MustCompile(`(url\(\s*["']?/static/)([^"')\s?#]+)`)
+
+// The assetManifest type holds the hashes of all the static files. It is built
+// once at startup together with the template cache, so the files must not be
+// changed while the application is running. The exception is development
+// (plain is true), where the files are referenced by their own names without
+// integrity hashes, so that the changes show up as soon as the page is reloaded.
+type assetManifest struct {
+	fsys     fs.FS
+	plain    bool
+	byName   map[string]*staticAsset
+	byHashed map[string]*staticAsset
+}
+
+// The newAssetManifest function hashes every file in fsys (the static directory
+// of the UI files). The stylesheets are hashed after the other files, because
+// their contents depend on the hashed names of the fonts and images they use.
+func newAssetManifest(fsys fs.FS, plain bool) (*assetManifest, error) {
+	m := &assetManifest{
+		fsys:     fsys,
+		plain:    plain,
+		byName:   map[string]*staticAsset{},
+		byHashed: map[string]*staticAsset{},
+	}
+
+	var stylesheets []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if path.Ext(name) == ".css" {
+			stylesheets = append(stylesheets, name)
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		m.add(name, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range stylesheets {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		// In development the files are served by their own names, so there is
+		// nothing to rewrite.
+		if !plain {
+			data, err = m.rewriteCSS(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		a := m.add(name, data)
+		if !plain {
+			a.Data = data
+		}
+	}
+	return m, nil
+}
+
+// The add method hashes the contents of a file and adds it to the manifest.
+func (m *assetManifest) add(name string, data []byte) *staticAsset {
+	sum := sha512.Sum384(data)
+
+	// css/main.css -> css/main.<hash>.css
+	ext := path.Ext(name)
+	a := &staticAsset{
+		Name:      name,
+		Hashed:    strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext,
+		Integrity: "sha384-" + base64.StdEncoding.EncodeToString(sum[:]),
+		ETag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+	m.byName[a.Name] = a
+	m.byHashed[a.Hashed] = a
+	return a
+}
+
+// The rewriteCSS method replaces the references to the static files in a
+// stylesheet with their hashed URLs, so that the fonts and the images can be
+// cached forever too. A reference to an unknown file is an error, which is
+// better found at startup than by the users.
+func (m *assetManifest) rewriteCSS(data []byte) ([]byte, error) {
+	var err error
+	data = cssURL.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := cssURL.FindSubmatch(match)
+		a, ok := m.byName[string(groups[2])]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unknown static file %q", groups[2])
+			}
+			return match
+		}
+		return append(append([]byte{}, groups[1]...), a.Hashed...)
+	})
+	return data, err
+}
+
+// The url method returns the URL of the hashed version of a static file, for
+// example "/static/css/main.1a2b3c4d5e6f.css" for "css/main.css". It is the
+// "static" function of the templates.
+func (m *assetManifest) url(name string) (string, error) {
+	a, ok := m.byName[name]
+	if !ok {
+		return "", fmt.Errorf("unknown static file %q", name)
+	}
+	if m.plain {
+		return "/static/" + a.Name, nil
+	}
+	return "/static/" + a.Hashed, nil
+}
+
+// The integrity method returns the subresource integrity hash of a static file.
+// It is the "integrity" function of the templates.
+func (m *assetManifest) integrity(name string) (string, error) {
+	a, ok := m.byName[name]
+	if !ok {
+		return "", fmt.Errorf("unknown static file %q", name)
+	}
+	if m.plain {
+		return "", nil
+	}
+	return a.Integrity, nil
+}
+
+// The funcs method returns the template functions which reference the static files.
+func (m *assetManifest) funcs() template.FuncMap {
+	return template.FuncMap{
+		"static":    m.url,
+		"integrity": m.integrity,
+	}
+}
+
+// The fileServer method returns a handler which serves the static files both
+// under their own names and under the hashed ones. It expects the /static
+// prefix to be stripped from the path. A hashed name always refers to the same
+// content, so browsers may keep such files forever; the files requested by their
+// own names are revalidated with the ETag. Directories aren't listed.
+func (m *assetManifest) fileServer() http.Handler {
+	fileServer := http.FileServer(noDirs{http.FS(m.fsys)})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		a, hashed := m.byHashed[name]
+		if hashed {
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = "/" + a.Name
+			r = r2
+		} else {
+			a = m.byName[name]
+		}
+
+		switch {
+		case a == nil:
+		case m.plain:
+			// In development the files on disk may differ from the hashed ones.
+			w.Header().Set("Cache-Control", "no-cache")
+		case hashed:
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("ETag", a.ETag)
+		default:
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", a.ETag)
+		}
+		if a != nil && a.Data != nil {
+			http.ServeContent(w, r, a.Name, time.Time{}, bytes.NewReader(a.Data))
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// The noDirs type hides the directories of a file system from http.FileServer,
+// so that it responds with 404 Not Found instead of listing their contents.
+type noDirs struct {
+	fs http.FileSystem
+}
+
+func (d noDirs) Open(name string) (http.File, error) {
+	f, err := d.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
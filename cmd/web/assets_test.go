@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssetManifest(t *testing.T) {
+	css := []byte("body { color: black; }")
+	m, err := newAssetManifest(fstest.MapFS{
+		"css/main.css": {Data: css},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := m.url("css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^/static/css/main\.[0-9a-f]{12}\.css$`).MatchString(url) {
+		t.Errorf("want a hashed URL; got %q", url)
+	}
+
+	sum := sha512.Sum384(css)
+	want := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	integrity, err := m.integrity("css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if integrity != want {
+		t.Errorf("want %q; got %q", want, integrity)
+	}
+
+	_, err = m.url("css/missing.css")
+	if err == nil {
+		t.Errorf("want an error for an unknown file")
+	}
+
+	// The file is served under both names, anything else (including the
+	// directories) is not found.
+	a := m.byName["css/main.css"]
+	tests := []struct {
+		name        string
+		urlPath     string
+		ifNoneMatch string
+		wantCode    int
+		wantCache   string
+	}{
+		{"Hashed name", url, "", http.StatusOK, "public, max-age=31536000, immutable"},
+		{"Plain name", "/static/css/main.css", "", http.StatusOK, "no-cache"},
+		{"Not modified", "/static/css/main.css", a.ETag, http.StatusNotModified, "no-cache"},
+		{"Not modified (weak)", "/static/css/main.css", "W/" + a.ETag, http.StatusNotModified, "no-cache"},
+		{"Modified", "/static/css/main.css", `"0123"`, http.StatusOK, "no-cache"},
+		{"Wrong hash", "/static/css/main.000000000000.css", "", http.StatusNotFound, ""},
+		{"Directory", "/static/css/", "", http.StatusNotFound, ""},
+		{"Root directory", "/static/", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, tt.urlPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			http.StripPrefix("/static", m.fileServer()).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if cc := rs.Header.Get("Cache-Control"); cc != tt.wantCache {
+				t.Errorf("want Cache-Control %q; got %q", tt.wantCache, cc)
+			}
+			if rs.StatusCode == http.StatusOK {
+				body, err := ioutil.ReadAll(rs.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(body) != string(css) {
+					t.Errorf("want body %q; got %q", css, body)
+				}
+			}
+		})
+	}
+}
+
+func TestAssetManifestCSS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/main.css":   {Data: []byte(`@font-face { src: url("/static/fonts/x.woff2") format("woff2"); } h1 { background: url(/static/img/logo.png); }`)},
+		"fonts/x.woff2":  {Data: []byte("font")},
+		"img/logo.png":   {Data: []byte("logo")},
+		"css/broken.css": {Data: []byte(`h1 { background: url('/static/img/missing.png'); }`)},
+	}
+
+	_, err := newAssetManifest(fsys, false)
+	if err == nil || !strings.Contains(err.Error(), "img/missing.png") {
+		t.Errorf("want an error for the missing image; got %v", err)
+	}
+
+	delete(fsys, "css/broken.css")
+	m, err := newAssetManifest(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	font, err := m.url("fonts/x.woff2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logo, err := m.url("img/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `@font-face { src: url("` + font + `") format("woff2"); } h1 { background: url(` + logo + `); }`
+
+	// The rewritten stylesheet is served and hashed, not the one on disk.
+	url, err := m.url("css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	http.StripPrefix("/static", m.fileServer()).ServeHTTP(rr, r)
+	if body := rr.Body.String(); body != want {
+		t.Errorf("want body %q; got %q", want, body)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("want Content-Type text/css; got %q", ct)
+	}
+	sum := sha512.Sum384([]byte(want))
+	integrity, err := m.integrity("css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if integrity != "sha384-"+base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Errorf("want the integrity of the rewritten stylesheet; got %q", integrity)
+	}
+
+	// In development the stylesheet is left as it is.
+	m, err = newAssetManifest(fsys, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := m.byName["css/main.css"]; a.Data != nil {
+		t.Errorf("want the stylesheet served from disk; got %q", a.Data)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// The certReloader type serves the TLS certificate from the files and reloads it
+// when they change, so that a renewed certificate is picked up without a restart.
+// The files are checked during the TLS handshakes, at most once per interval.
+// If the new files can't be loaded (for example, the certificate has already been
+// replaced, but the key hasn't), the old certificate is served until they can.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *logger.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // the latest modification time of the loaded files
+	checked time.Time
+}
+
+// The newCertReloader function loads the certificate. Unlike the later reloads,
+// the first one must succeed.
+func newCertReloader(certFile, keyFile string, interval time.Duration, logger *logger.Logger) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   logger,
+		checked:  time.Now(),
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// The GetCertificate method is used as tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		old := c.cert
+		if err := c.reload(); err != nil {
+			c.logger.Error("reloading TLS certificate", "error", err)
+		} else if c.cert != old {
+			c.logger.Info("reloaded TLS certificate", "file", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	var modTime time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+// The certificateEmail function returns the identity of a client certificate: the
+// first email address in its alternative names or, if there is none, the common
+// name if it looks like an email address.
+func certificateEmail(cert *x509.Certificate) string {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if strings.Contains(cert.Subject.CommonName, "@") {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// The selfSignedCertificate function generates a certificate for the given host
+// names and IP addresses. It is only kept in memory and is meant for development,
+// when there is no certificate in ./tls: the browser will warn about it, but
+// there is no need to run generate_cert.go first.
+func selfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Snippetbox development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := selfSignedCertificate("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+	if err := leaf.VerifyHostname("example.com"); err == nil {
+		t.Error("want example.com to be rejected")
+	}
+}
+
+// The writeCertificate helper writes the certificate and its key as PEM files.
+func writeCertificate(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	first, err := selfSignedCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCertificate(t, first, certFile, keyFile)
+
+	discard, err := logger.New(ioutil.Discard, logger.FormatText, logger.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := newCertReloader(certFile, keyFile, 0, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving := func() []byte {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Certificate[0]
+	}
+	// Make the files look newer, as if they had been replaced a bit later.
+	touch := func(d time.Duration) {
+		for _, path := range []string{certFile, keyFile} {
+			if err := os.Chtimes(path, time.Now().Add(d), time.Now().Add(d)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if string(serving()) != string(first.Certificate[0]) {
+		t.Fatal("want the first certificate")
+	}
+
+	second, err := selfSignedCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCertificate(t, second, certFile, keyFile)
+	touch(time.Minute)
+	if string(serving()) != string(second.Certificate[0]) {
+		t.Error("want the second certificate after the files have changed")
+	}
+
+	// A broken key must not replace the working certificate.
+	err = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch(2 * time.Minute)
+	if string(serving()) != string(second.Certificate[0]) {
+		t.Error("want the second certificate to be kept")
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile, 0, discard); err == nil {
+		t.Error("want error for missing files")
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name       string
+		email      string
+		commonName string
+		verified   bool
+		wantUser   bool
+	}{
+		{"Known user", "alice@example.com", "", true, true},
+		{"Email in common name", "", "alice@example.com", true, true},
+		{"Unknown user", "mallory@example.com", "", true, false},
+		{"No email", "", "Alice", true, false},
+		{"Unverified certificate", "alice@example.com", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.commonName}}
+			if tt.email != "" {
+				cert.EmailAddresses = []string{tt.email}
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			if tt.verified {
+				r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+			}
+
+			var user *models.User
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = app.authenticatedUser(r)
+			})
+			rr := httptest.NewRecorder()
+			app.session.Enable(app.authenticate(next)).ServeHTTP(rr, r)
+
+			if (user != nil) != tt.wantUser {
+				t.Errorf("want user %v; got %v", tt.wantUser, user)
+			}
+		})
+	}
+}
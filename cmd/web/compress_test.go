@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"Empty", "", ""},
+		{"Gzip", "gzip", "gzip"},
+		{"Browser", "gzip, deflate, br", "gzip"},
+		{"Brotli preferred", "br;q=1.0, gzip;q=0.8", "gzip"},
+		{"Only brotli", "br", ""},
+		{"Gzip refused", "gzip;q=0, deflate", ""},
+		{"Wildcard", "*", "gzip"},
+		{"Wildcard with gzip refused", "*, gzip;q=0", ""},
+		{"Case and spaces", " GZIP ; q=0.5 ", "gzip"},
+		{"Identity", "identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	app := newTestApplication(t)
+	page := strings.Repeat("<p>Hello, world!</p>\n", 100)
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		rangeHeader    string
+		contentType    string
+		contentLength  bool
+		body           string
+		wantGzip       bool
+	}{
+		{"HTML", http.MethodGet, "gzip, deflate, br", "", "text/html; charset=utf-8", false, page, true},
+		{"Sniffed HTML", http.MethodGet, "gzip", "", "", false, page, true},
+		{"No Accept-Encoding", http.MethodGet, "", "", "text/html; charset=utf-8", false, page, false},
+		{"Image", http.MethodGet, "gzip", "", "image/png", false, page, false},
+		{"Small body", http.MethodGet, "gzip", "", "text/css", true, "body { color: black; }", false},
+		{"Large body", http.MethodGet, "gzip", "", "text/css", true, page, true},
+		{"Range", http.MethodGet, "gzip", "bytes=0-10", "text/css", true, page, false},
+		{"HEAD", http.MethodHead, "gzip", "", "text/html; charset=utf-8", false, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				}
+				w.Header().Set("ETag", `"abc"`)
+				w.Write([]byte(tt.body))
+			})
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if tt.rangeHeader != "" {
+				r.Header.Set("Range", tt.rangeHeader)
+			}
+			app.compress(next).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			if vary := rs.Header.Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("want Vary %q; got %q", "Accept-Encoding", vary)
+			}
+
+			body, err := ioutil.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantGzip {
+				if ce := rs.Header.Get("Content-Encoding"); ce != "" {
+					t.Errorf("want no Content-Encoding; got %q", ce)
+				}
+				if string(body) != tt.body {
+					t.Errorf("want the body unchanged")
+				}
+				return
+			}
+
+			if ce := rs.Header.Get("Content-Encoding"); ce != "gzip" {
+				t.Errorf("want Content-Encoding %q; got %q", "gzip", ce)
+			}
+			if cl := rs.Header.Get("Content-Length"); cl != "" {
+				t.Errorf("want no Content-Length; got %q", cl)
+			}
+			if etag := rs.Header.Get("ETag"); etag != `W/"abc"` {
+				t.Errorf("want ETag %q; got %q", `W/"abc"`, etag)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := ioutil.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(plain) != tt.body {
+				t.Errorf("want the decompressed body to match")
+			}
+		})
+	}
+}
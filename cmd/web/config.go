@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"github.com/Dimau/snippetbox/pkg/ratelimit"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every setting can also be given with an environment variable: the name of the
+// flag in upper case with this prefix and dashes replaced by underscores
+// (for example, -smtp-host becomes SNIPPETBOX_SMTP_HOST).
+const configEnvPrefix = "SNIPPETBOX_"
+
+// The settings which only make sense on the command line.
+var commandLineOnly = map[string]bool{"config": true, "print-config": true}
+
+// The settings which -print-config doesn't show. The DSN is printed without the password.
+var secretSettings = map[string]bool{"secret": true, "smtp-password": true}
+
+// Versions of TLS which can be set with -tls-min-version.
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// Modes of -tls-client-auth. The certificates are always verified against -tls-client-ca.
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// The config type holds all settings of the application. They are loaded by
+// loadConfig from (in the order of increasing precedence) the defaults, the
+// configuration file, the environment variables and the command-line flags.
+type config struct {
+	ConfigFile  string
+	PrintConfig bool
+
+	Addr            string
+	BaseURL         string
+	Dev             bool
+	DSN             string
+	Env             string
+	LockoutStore    string
+	LogFormat       string
+	LogLevel        string
+	MetricsAddr     string
+	OIDCConfig      string
+	Secret          string
+	SecretFile      string
+	SessionLifetime time.Duration
+
+	Server struct {
+		DrainDelay     time.Duration
+		DrainTimeout   time.Duration
+		IdleTimeout    time.Duration
+		PlainHTTP      bool
+		ReadTimeout    time.Duration
+		RedirectAddr   string
+		TrustedProxies string
+		WriteTimeout   time.Duration
+	}
+
+	SMTP struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+		Sender   string
+	}
+
+	Security struct {
+		CSP           string
+		CSPReportOnly bool
+		HSTSMaxAge    time.Duration
+	}
+
+	TLS struct {
+		CertFile     string
+		KeyFile      string
+		MinVersion   string
+		CipherSuites string
+		ClientAuth   string
+		ClientCA     string
+	}
+
+	RateLimit struct {
+		Create string
+		Email  string
+		Signup string
+	}
+
+	Tracing struct {
+		Exporter string
+		File     string
+	}
+
+	flags *flag.FlagSet
+}
+
+// The flagSet method defines a flag for every setting, with its default value.
+// The flags are the single list of the settings: the keys of the configuration
+// file and the names of the environment variables are derived from them.
+func (cfg *config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", "", "Path to a configuration file in JSON format")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", false, "Print the effective configuration (with the secrets redacted) and exit")
+
+	fs.StringVar(&cfg.Addr, "addr", ":4000", "HTTP network address")
+	fs.StringVar(&cfg.BaseURL, "base-url", "https://localhost:4000", "Public URL of the site, used for links in emails")
+	fs.BoolVar(&cfg.Dev, "dev", false, "Read the templates and the static files from ./ui and reload the templates on every request")
+	fs.StringVar(&cfg.DSN, "dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	fs.StringVar(&cfg.Env, "env", "development", "Environment: development or production")
+	fs.StringVar(&cfg.LockoutStore, "lockout-store", "memory", "Where to keep failed login counters: memory or mysql")
+	fs.StringVar(&cfg.LogFormat, "log-format", logger.FormatText, "Format of the log: text or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Minimum level of the log entries: debug, info, warn or error")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "Address of a separate plain HTTP listener for /metrics, for example 127.0.0.1:9100 (served on the main listener if empty)")
+	fs.StringVar(&cfg.OIDCConfig, "oidc-config", "", "Path to a JSON file with the OpenID Connect providers")
+	fs.StringVar(&cfg.Secret, "secret", defaultSecret, "Secret key of the session cookies (32 characters)")
+	fs.StringVar(&cfg.SecretFile, "secret-file", "", "Path to a file with the session keys, the active one first (overrides "+sessionKeysEnv+" and -secret)")
+	fs.DurationVar(&cfg.SessionLifetime, "session-lifetime", 12*time.Hour, "Lifetime of the session cookies")
+
+	fs.DurationVar(&cfg.Server.DrainDelay, "drain-delay", 0, "How long /ping reports draining before the server stops accepting connections on shutdown")
+	fs.DurationVar(&cfg.Server.DrainTimeout, "drain-timeout", 30*time.Second, "How long to wait for in-flight requests and background tasks on shutdown")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", time.Minute, "How long to keep idle keep-alive connections open")
+	fs.BoolVar(&cfg.Server.PlainHTTP, "plain-http", false, "Serve plain HTTP, for running behind a proxy which terminates TLS")
+	fs.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", 5*time.Second, "Maximum duration for reading a request")
+	fs.StringVar(&cfg.Server.RedirectAddr, "redirect-addr", "", "Address of an additional plain HTTP listener which redirects to -base-url, for example :80")
+	fs.StringVar(&cfg.Server.TrustedProxies, "trusted-proxies", "", "Comma-separated IP addresses or CIDR networks of the proxies whose X-Forwarded-For and X-Forwarded-Proto headers are trusted")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", 10*time.Second, "Maximum duration for writing a response")
+
+	fs.StringVar(&cfg.SMTP.Host, "smtp-host", "", "SMTP host (emails are written to the log if empty)")
+	fs.IntVar(&cfg.SMTP.Port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.SMTP.Username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.SMTP.Password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.SMTP.Sender, "smtp-sender", "Snippetbox <no-reply@snippetbox.local>", "SMTP sender")
+
+	fs.StringVar(&cfg.Security.CSP, "csp", defaultCSP, "Content Security Policy, {nonce} is replaced with the nonce of the request")
+	fs.BoolVar(&cfg.Security.CSPReportOnly, "csp-report-only", false, "Only report violations of the Content Security Policy, don't enforce it")
+	fs.DurationVar(&cfg.Security.HSTSMaxAge, "hsts-max-age", 365*24*time.Hour, "Max age of the Strict-Transport-Security header (0 to turn it off)")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", "./tls/cert.pem", "Path to the TLS certificate")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", "./tls/key.pem", "Path to the private key of the TLS certificate")
+	fs.StringVar(&cfg.TLS.MinVersion, "tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
+	fs.StringVar(&cfg.TLS.ClientAuth, "tls-client-auth", "none", "Client certificates: none, optional or require; a valid certificate logs in the user with its email address")
+	fs.StringVar(&cfg.TLS.ClientCA, "tls-client-ca", "", "Path to the PEM file with the CA certificates which sign the client certificates")
+	fs.StringVar(&cfg.TLS.CipherSuites, "tls-cipher-suites", "", "Comma-separated TLS 1.2 cipher suites, for example TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 (Go's defaults if empty)")
+
+	fs.StringVar(&cfg.RateLimit.Create, "rate-limit-create", "20/10m", "How often a user may create snippets and teams: N/duration (N requests per duration) or off")
+	fs.StringVar(&cfg.RateLimit.Email, "rate-limit-email", "5/10m", "How often a user (or an IP address) may request emails: password resets, email changes and team invitations")
+	fs.StringVar(&cfg.RateLimit.Signup, "rate-limit-signup", "5/1h", "How often an IP address may sign up")
+
+	fs.StringVar(&cfg.Tracing.Exporter, "trace-exporter", "none", "Where to export the tracing spans: none, stdout or file")
+	fs.StringVar(&cfg.Tracing.File, "trace-file", "", "Path to the file the spans are appended to with -trace-exporter file")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", name)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery setting can also be given in the configuration file (key = flag name) or with the\n"+
+			"%s<FLAG_NAME> environment variable. Flags override environment variables, which override the file.\n", configEnvPrefix)
+	}
+	return fs
+}
+
+// The configEnv function returns the name of the environment variable of a setting.
+func configEnv(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// The loadConfig function loads the settings from the command-line arguments args,
+// the environment (read with lookupEnv) and the configuration file, which is given
+// with the -config flag or the SNIPPETBOX_CONFIG environment variable. The values
+// are applied in this order, so the later sources override the earlier ones:
+//   - the defaults;
+//   - the configuration file;
+//   - the environment variables;
+//   - the command-line flags.
+//
+// An environment variable which is set overrides the file even if it is empty, so
+// a setting of the file can be cleared without editing it.
+//
+// The settings are validated, so a returned config is ready to use.
+func loadConfig(name string, args []string, lookupEnv func(string) (string, bool)) (*config, error) {
+	cfg := &config{}
+	fs := cfg.flagSet(name)
+	cfg.flags = fs
+
+	// The flags are parsed first to find out the path of the configuration file,
+	// and once again at the end to override the file and the environment.
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	path := cfg.ConfigFile
+	if path == "" {
+		path, _ = lookupEnv(configEnv("config"))
+	}
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			key := strings.ReplaceAll(v.key, "_", "-")
+			if fs.Lookup(key) == nil || commandLineOnly[key] {
+				return nil, fmt.Errorf("%s: unknown setting %q", path, v.key)
+			}
+			if err := fs.Set(key, v.value); err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid value %q: %v", path, v.key, v.value, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := configEnv(f.Name)
+		value, ok := lookupEnv(env)
+		if err != nil || commandLineOnly[f.Name] || !ok {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%s: invalid value %q: %v", env, value, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// The configError type lists all problems of a configuration, so that they can
+// be fixed at once instead of one per start.
+type configError []string
+
+func (e configError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// The validate method checks the values of the settings.
+func (cfg *config) validate() error {
+	var problems configError
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.Addr != "", "addr: must not be empty")
+	u, err := url.Parse(cfg.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"base-url: %q is not an absolute http(s) URL", cfg.BaseURL)
+	check(cfg.DSN != "", "dsn: must not be empty")
+	check(cfg.Env == "development" || cfg.Env == "production", "env: must be development or production, not %q", cfg.Env)
+	check(cfg.LockoutStore == "memory" || cfg.LockoutStore == "mysql", "lockout-store: must be memory or mysql, not %q", cfg.LockoutStore)
+	check(cfg.LogFormat == logger.FormatText || cfg.LogFormat == logger.FormatJSON, "log-format: must be text or json, not %q", cfg.LogFormat)
+	_, err = logger.ParseLevel(cfg.LogLevel)
+	check(err == nil, "log-level: must be debug, info, warn or error, not %q", cfg.LogLevel)
+	check(cfg.SessionLifetime > 0, "session-lifetime: must be positive")
+
+	check(cfg.Server.DrainDelay >= 0, "drain-delay: must not be negative")
+	check(cfg.Server.DrainTimeout > 0, "drain-timeout: must be positive")
+	check(cfg.Server.IdleTimeout > 0, "idle-timeout: must be positive")
+	check(cfg.Server.ReadTimeout > 0, "read-timeout: must be positive")
+	check(cfg.Server.WriteTimeout > 0, "write-timeout: must be positive")
+	check(cfg.MetricsAddr == "" || cfg.MetricsAddr != cfg.Addr, "metrics-addr: must differ from addr")
+	check(cfg.Server.RedirectAddr == "" || !cfg.Server.PlainHTTP, "redirect-addr: can't be used with plain-http")
+	check(cfg.Server.RedirectAddr == "" || strings.HasPrefix(cfg.BaseURL, "https://"), "redirect-addr: base-url must be an https URL")
+	_, err = parseTrustedProxies(cfg.Server.TrustedProxies)
+	check(err == nil, "trusted-proxies: %v", err)
+
+	check(cfg.SMTP.Port > 0 && cfg.SMTP.Port < 65536, "smtp-port: %d is not a valid port", cfg.SMTP.Port)
+
+	check(cfg.Security.CSP != "", "csp: must not be empty")
+	check(cfg.Security.HSTSMaxAge >= 0, "hsts-max-age: must not be negative")
+
+	check(cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != "", "tls-cert, tls-key: the certificate and the key are required")
+	_, ok := tlsVersions[cfg.TLS.MinVersion]
+	check(ok, "tls-min-version: must be 1.2 or 1.3, not %q", cfg.TLS.MinVersion)
+	_, err = cipherSuites(cfg.TLS.CipherSuites)
+	check(err == nil, "tls-cipher-suites: %v", err)
+	_, ok = tlsClientAuth[cfg.TLS.ClientAuth]
+	check(ok, "tls-client-auth: must be none, optional or require, not %q", cfg.TLS.ClientAuth)
+	check(cfg.TLS.ClientAuth == "none" || cfg.TLS.ClientCA != "", "tls-client-auth: tls-client-ca is required")
+	check(cfg.TLS.ClientAuth == "none" || !cfg.Server.PlainHTTP, "tls-client-auth: can't be used with plain-http")
+
+	limits := cfg.rateLimits()
+	for _, group := range []string{"create", "email", "signup"} {
+		_, err = parseRateLimit(limits[group])
+		check(err == nil, "rate-limit-%s: %v", group, err)
+	}
+
+	check(cfg.Tracing.Exporter == "none" || cfg.Tracing.Exporter == "stdout" || cfg.Tracing.Exporter == "file",
+		"trace-exporter: must be none, stdout or file, not %q", cfg.Tracing.Exporter)
+	check(cfg.Tracing.Exporter != "file" || cfg.Tracing.File != "", "trace-exporter: trace-file is required")
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// The parseTrustedProxies function parses comma-separated IP addresses and CIDR
+// networks. A single address is turned into a network of one address.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR network", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// The cipherSuites function returns the IDs of the comma-separated cipher suites.
+// Only the suites which Go considers secure are accepted.
+func cipherSuites(names string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// The tlsConfig method returns the TLS settings of the server.
+//
+// The tls.Config.CurvePreferences field lets us specify which elliptic curves should be given preference
+// during the TLS handshake. Go supports a few elliptic curves, but as of Go 1.11 only
+// tls.CurveP256 and tls.X25519 have assembly implementations. The others are very CPU intensive,
+// so omitting them helps ensure that our server will remain performant under heavy loads.
+//
+// The cipher suites only apply to TLS 1.2: the suites of TLS 1.3 are not configurable.
+func (cfg *config) tlsConfig() (*tls.Config, error) {
+	suites, err := cipherSuites(cfg.TLS.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		PreferServerCipherSuites: true,
+		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		MinVersion:               tlsVersions[cfg.TLS.MinVersion],
+		CipherSuites:             suites,
+		ClientAuth:               tlsClientAuth[cfg.TLS.ClientAuth],
+	}
+
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		data, err := ioutil.ReadFile(cfg.TLS.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", cfg.TLS.ClientCA)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// The print method writes the effective configuration in the format of a JSON
+// configuration file. The secrets are replaced with "[redacted]".
+func (cfg *config) print(w io.Writer) error {
+	settings := map[string]interface{}{}
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		if commandLineOnly[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		switch {
+		case secretSettings[f.Name] && value != "":
+			value = "[redacted]"
+		case f.Name == "dsn":
+			value = redactDSN(value)
+		}
+		switch v := f.Value.(flag.Getter).Get().(type) {
+		case bool, int:
+			settings[f.Name] = v
+		default:
+			settings[f.Name] = value
+		}
+	})
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(settings)
+}
+
+// The rateLimits method returns the rate limits of the route groups.
+func (cfg *config) rateLimits() map[string]string {
+	return map[string]string{
+		"create": cfg.RateLimit.Create,
+		"email":  cfg.RateLimit.Email,
+		"signup": cfg.RateLimit.Signup,
+	}
+}
+
+// The parseRateLimit function parses a rate limit such as 20/10m (20 requests per
+// 10 minutes, all of which can be made at once). It returns nil for off.
+func parseRateLimit(s string) (*ratelimit.Limit, error) {
+	if s == "off" {
+		return nil, nil
+	}
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("%q is not N/duration or off", s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("%q is not a positive number of requests", s[:i])
+	}
+	period, err := time.ParseDuration(s[i+1:])
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("%q is not a positive duration", s[i+1:])
+	}
+	limit := ratelimit.Every(n, period)
+	return &limit, nil
+}
+
+// The redactDSN function hides the password in a MySQL data source name.
+func redactDSN(dsn string) string {
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "[redacted]"
+	}
+	if c.Passwd != "" {
+		c.Passwd = "[redacted]"
+	}
+	return c.FormatDSN()
+}
+
+// A configValue is a setting read from the configuration file.
+type configValue struct {
+	key   string
+	value string
+}
+
+// The readConfigFile function reads the settings from a JSON configuration file.
+//
+// The keys are the names of the flags. They can be grouped into objects:
+// smtp-host can be written as host in the "smtp" object.
+func readConfigFile(path string) ([]configValue, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".json" {
+		return nil, fmt.Errorf("%s: unsupported configuration format %q (use .json)", path, ext)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseJSONConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return values, nil
+}
+
+// The parseJSONConfig function flattens a JSON object into the settings: nested
+// objects are joined with dashes and arrays of strings with commas.
+func parseJSONConfig(data []byte) ([]configValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var root map[string]interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	var values []configValue
+	seen := map[string]bool{}
+	var flatten func(prefix string, obj map[string]interface{}) error
+	flatten = func(prefix string, obj map[string]interface{}) error {
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := prefix + key
+			var value string
+			if _, ok := obj[key].(map[string]interface{}); !ok {
+				if seen[name] {
+					return fmt.Errorf("%s is set twice", name)
+				}
+				seen[name] = true
+			}
+			switch v := obj[key].(type) {
+			case map[string]interface{}:
+				if err := flatten(name+"-", v); err != nil {
+					return err
+				}
+				continue
+			case string:
+				value = v
+			case json.Number:
+				value = v.String()
+			case bool:
+				value = strconv.FormatBool(v)
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					s, ok := item.(string)
+					if !ok {
+						return fmt.Errorf("%s: only arrays of strings are supported", name)
+					}
+					items[i] = s
+				}
+				value = strings.Join(items, ",")
+			default:
+				return fmt.Errorf("%s: unsupported value %v", name, v)
+			}
+			values = append(values, configValue{key: name, value: value})
+		}
+		return nil
+	}
+	if err := flatten("", root); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
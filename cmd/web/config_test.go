@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "snippetbox.json")
+	err := ioutil.WriteFile(file, []byte(`{
+  "addr": ":5000",
+  "read-timeout": "7s",
+  "env": "production",
+  "csp-report-only": true,
+  "smtp": {"host": "smtp.example.com", "port": 587},
+  "tls": {"cipher_suites": ["TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"]}
+}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		args         []string
+		lookupEnv    func(string) (string, bool)
+		wantAddr     string
+		wantPort     int
+		wantTimeout  time.Duration
+		wantSMTPHost string
+	}{
+		{"Defaults", nil, env(nil), ":4000", 25, 5 * time.Second, ""},
+		{"File", []string{"-config", file}, env(nil), ":5000", 587, 7 * time.Second, "smtp.example.com"},
+		{"File from environment", nil, env(map[string]string{"SNIPPETBOX_CONFIG": file}), ":5000", 587, 7 * time.Second, "smtp.example.com"},
+		{"Environment over file", []string{"-config", file}, env(map[string]string{"SNIPPETBOX_ADDR": ":7000", "SNIPPETBOX_SMTP_PORT": "465"}), ":7000", 465, 7 * time.Second, "smtp.example.com"},
+		{"Empty environment over file", []string{"-config", file}, env(map[string]string{"SNIPPETBOX_SMTP_HOST": ""}), ":5000", 587, 7 * time.Second, ""},
+		{"Flag over environment", []string{"-config", file, "-addr", ":8000", "-read-timeout", "1s"}, env(map[string]string{"SNIPPETBOX_ADDR": ":7000"}), ":8000", 587, time.Second, "smtp.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig("web", tt.args, tt.lookupEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Addr != tt.wantAddr {
+				t.Errorf("want addr %q; got %q", tt.wantAddr, cfg.Addr)
+			}
+			if cfg.SMTP.Port != tt.wantPort {
+				t.Errorf("want smtp port %d; got %d", tt.wantPort, cfg.SMTP.Port)
+			}
+			if cfg.Server.ReadTimeout != tt.wantTimeout {
+				t.Errorf("want read timeout %v; got %v", tt.wantTimeout, cfg.Server.ReadTimeout)
+			}
+			if cfg.SMTP.Host != tt.wantSMTPHost {
+				t.Errorf("want smtp host %q; got %q", tt.wantSMTPHost, cfg.SMTP.Host)
+			}
+		})
+	}
+
+	cfg, err := loadConfig("web", []string{"-config", file}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlsConfig.CipherSuites) != 2 {
+		t.Errorf("want 2 cipher suites; got %d", len(tlsConfig.CipherSuites))
+	}
+	if cfg.Env != "production" || !cfg.Security.CSPReportOnly {
+		t.Errorf("want env production and csp-report-only; got %q and %v", cfg.Env, cfg.Security.CSPReportOnly)
+	}
+}
+
+// The env function returns a lookupEnv function with the given variables set.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	none := env(nil)
+
+	tests := []struct {
+		name      string
+		args      []string
+		lookupEnv func(string) (string, bool)
+		wantErr   string
+	}{
+		{"Unknown key", []string{"-config", write("unknown.json", `{"addr": ":4000", "colour": "red"}`)}, none, `unknown.json: unknown setting "colour"`},
+		{"Malformed JSON", []string{"-config", write("malformed.json", `{"env": production}`)}, none, "malformed.json: invalid character"},
+		{"Set twice", []string{"-config", write("twice.json", `{"smtp-host": "a", "smtp": {"host": "b"}}`)}, none, "twice.json: smtp-host is set twice"},
+		{"Invalid duration", []string{"-config", write("duration.json", `{"read-timeout": "soon"}`)}, none, "duration.json: read-timeout: invalid value"},
+		{"Unsupported format", []string{"-config", write("config.toml", "addr = \":4000\"\n")}, none, `unsupported configuration format ".toml" (use .json)`},
+		{"Invalid environment variable", nil, env(map[string]string{"SNIPPETBOX_SMTP_PORT": "smtp"}), "SNIPPETBOX_SMTP_PORT: invalid value"},
+		{"Empty environment variable", nil, env(map[string]string{"SNIPPETBOX_SMTP_PORT": ""}), "SNIPPETBOX_SMTP_PORT: invalid value"},
+		{"Trusted proxies", []string{"-trusted-proxies", "10.0.0.1, 10.0.0.0/33"}, none, `trusted-proxies: "10.0.0.0/33" is not a CIDR network`},
+		{"Redirect without TLS", []string{"-plain-http", "-redirect-addr", ":80"}, none, "redirect-addr: can't be used with plain-http"},
+		{"Rate limit", []string{"-rate-limit-signup", "5"}, none, `rate-limit-signup: "5" is not N/duration or off`},
+		{"Trace exporter", []string{"-trace-exporter", "jaeger"}, none, `trace-exporter: must be none, stdout or file, not "jaeger"`},
+		{"Trace file", []string{"-trace-exporter", "file"}, none, "trace-exporter: trace-file is required"},
+		{"Validation", []string{"-env", "staging", "-smtp-port", "0", "-tls-cipher-suites", "TLS_RSA_WITH_RC4_128_SHA"}, none, "invalid configuration:\n" +
+			"  env: must be development or production, not \"staging\"\n" +
+			"  smtp-port: 0 is not a valid port\n" +
+			"  tls-cipher-suites: unknown or insecure cipher suite \"TLS_RSA_WITH_RC4_128_SHA\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig("web", tt.args, tt.lookupEnv)
+			if err == nil {
+				t.Fatal("want error; got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("want error to contain %q; got %q", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	args := []string{"-dsn", "web:hunter2@tcp(db:3306)/snippetbox?parseTime=true", "-smtp-password", "hunter2", "-secret", newSessionKey}
+	cfg, err := loadConfig("web", args, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	if strings.Contains(out, "hunter2") || strings.Contains(out, newSessionKey) {
+		t.Errorf("want secrets redacted; got %q", out)
+	}
+	for _, want := range []string{
+		`"dsn": "web:[redacted]@tcp(db:3306)/snippetbox?parseTime=true",`,
+		`"secret": "[redacted]",`,
+		`"smtp-port": 25,`,
+		`"read-timeout": "5s",`,
+		`"dev": false,`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("want output to contain %q; got %q", want, out)
+		}
+	}
+
+	// The printed configuration can be read back as a configuration file.
+	if _, err := parseJSONConfig(buf.Bytes()); err != nil {
+		t.Errorf("want printed config to parse; got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// The Content Security Policy which the -csp flag defaults to. Every occurrence
+// of {nonce} is replaced with the nonce of the request, which the templates put
+// into the nonce attribute of the <script> tags. Browsers send the reports about
+// violations of the policy to /csp-report.
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}'; " +
+	"style-src 'self'; " +
+	"font-src 'self'; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'none'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'; " +
+	"report-uri /csp-report"
+
+// The Permissions-Policy header turns off the browser features which the site doesn't use.
+const permissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=(), interest-cohort=()"
+
+// Reports larger than this are refused.
+const cspReportMaxBytes = 64 * 1024
+
+// The cspViolation type holds the parts of a CSP violation report which we log.
+type cspViolation struct {
+	DocumentURI string
+	BlockedURI  string
+	Directive   string
+}
+
+// The newCSPNonce function returns a new random nonce for the Content Security Policy.
+// The URL-safe alphabet is used, because html/template escapes '+' and '=' in attributes.
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The parseCSPReports function parses the body of a request to /csp-report. Browsers
+// send reports in one of two formats: a single {"csp-report": {...}} object (the
+// report-uri directive), or an array of reports of the Reporting API, where only
+// the reports of the "csp-violation" type are of interest to us.
+func parseCSPReports(body []byte) ([]cspViolation, error) {
+	var legacy struct {
+		Report *struct {
+			DocumentURI        string `json:"document-uri"`
+			BlockedURI         string `json:"blocked-uri"`
+			ViolatedDirective  string `json:"violated-directive"`
+			EffectiveDirective string `json:"effective-directive"`
+		} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err == nil && legacy.Report != nil {
+		directive := legacy.Report.EffectiveDirective
+		if directive == "" {
+			directive = legacy.Report.ViolatedDirective
+		}
+		return []cspViolation{{legacy.Report.DocumentURI, legacy.Report.BlockedURI, directive}}, nil
+	}
+
+	var reports []struct {
+		Type string `json:"type"`
+		Body struct {
+			DocumentURL        string `json:"documentURL"`
+			BlockedURL         string `json:"blockedURL"`
+			EffectiveDirective string `json:"effectiveDirective"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return nil, errors.New("invalid CSP report")
+	}
+	violations := []cspViolation{}
+	for _, rep := range reports {
+		if rep.Type == "csp-violation" {
+			violations = append(violations, cspViolation{rep.Body.DocumentURL, rep.Body.BlockedURL, rep.Body.EffectiveDirective})
+		}
+	}
+	return violations, nil
+}
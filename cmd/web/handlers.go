@@ -1,18 +1,68 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Dimau/snippetbox/pkg/forms"
 	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/oidc"
+	"github.com/Dimau/snippetbox/pkg/totp"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"regexp"
+	"rsc.io/qr"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// Links which we send by email are valid for this long after they were sent.
+const (
+	passwordResetTTL = 45 * time.Minute
+	emailChangeTTL   = 24 * time.Hour
+)
+
+// Settings of the two-factor authentication. The second step of the login
+// must be completed within twoFactorLoginTTL after the password was checked.
+const (
+	totpIssuer         = "Snippetbox"
+	twoFactorLoginTTL  = 5 * time.Minute
+	recoveryCodesCount = 10
+)
+
+// "Remember me" logins last for rememberTTL since the last visit.
+const (
+	rememberTTL        = 30 * 24 * time.Hour
+	rememberCookieName = "remember_token"
+)
+
+// The profile pages show this many snippets on a page.
+const profilePageSize = 10
+
+// Invitations to a team are valid for this long after they were sent.
+const teamInvitationTTL = 7 * 24 * time.Hour
+
+// The admin dashboard shows this many of the most recent users and snippets,
+// and the audit log page this many of the most recent events.
+const (
+	adminListLimit = 50
+	auditListLimit = 200
+)
+
+var totpCodeRX = regexp.MustCompile(`^[0-9]{6}$`)
+
+// errUnverifiedEmail is returned by linkIdentity if the identity provider
+// hasn't verified the email address of the user.
+var errUnverifiedEmail = errors.New("identity provider hasn't verified the email address")
+
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	s, err := app.snippets.Latest()
+	s, err := app.snippetModel(r).Latest()
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -32,26 +82,60 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 	// Use the SnippetModel object's Get method to retrieve the data for a
 	// specific record based on its ID. If no matching record is found,
 	// return a 404 Not Found response.
-	s, err := app.snippets.Get(id)
+	s, err := app.snippetModel(r).Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	// Snippets which the current user isn't allowed to see look exactly like
+	// missing ones, so that nobody can find out that they exist.
+	ok, err := app.canView(r, s)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	// Private and team snippets must not be kept by the browser or intermediary caches.
+	// Public ones may be kept by the browser (the page shows who is logged in, so
+	// not by shared caches), but only if it checks them with the ETag every time.
+	if s.Visibility == models.VisibilityPrivate || s.Visibility == models.VisibilityTeam {
+		w.Header().Add("Cache-Control", "no-store")
+	} else {
+		w.Header().Add("Cache-Control", "private, no-cache")
+	}
+
 	// Use the new render helper.
-	app.render(w, r, "show.page.tmpl", &templateData{
+	app.renderWithETag(w, r, "show.page.tmpl", &templateData{
 		Snippet: s,
 	})
 }
 
 // Add a new createSnippetForm handler, which for now returns a placeholder response.
 func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
+	app.renderCreateSnippet(w, r, forms.New(nil))
+}
+
+// The renderCreateSnippet helper shows the form for a new snippet. The form
+// lists the teams of the user, so that the snippet can be shared with one of them.
+func (app *application) renderCreateSnippet(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	teams, err := app.teams.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	app.render(w, r, "create.page.tmpl", &templateData{
-		Form: forms.New(nil),
+		Form:  form,
+		Teams: teams,
 	})
 }
 
@@ -72,12 +156,22 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	form.Required("title", "content", "expires")
 	form.MaxLength("title", 100)
 	form.PermittedValues("expires", "365", "7", "1")
+	form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate, models.VisibilityTeam)
+
+	// Team snippets belong to the team chosen in the form.
+	var teamID int
+	if form.Get("visibility") == models.VisibilityTeam {
+		teamID, err = strconv.Atoi(form.Get("team"))
+		if err != nil || teamID < 1 {
+			form.Errors.Add("team", "Choose a team")
+		}
+	}
 
 	// If the form isn't valid, redisplay the template passing in the form.Form object as the data
 	// If there are any validation errors, re-display the create.page.tmpl
 	// template passing in the validation errors and previously submitted r.PostForm data
 	if !form.Valid() {
-		app.render(w, r, "create.page.tmpl", &templateData{Form: form})
+		app.renderCreateSnippet(w, r, form)
 		return
 	}
 
@@ -87,11 +181,28 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	// Because the form data (with type url.Values) has been anonymously embedded
 	// in the form.Form struct, we can use the Get() method to retrieve
 	// the validated value for a particular form field.
-	id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
+	// The author of the snippet is the current user. The form always sends the
+	// visibility, but we fall back to public if the field is missing.
+	visibility := form.Get("visibility")
+	if visibility == "" {
+		visibility = models.VisibilityPublic
+	}
+	id, err := app.snippetModel(r).Insert(app.authenticatedUser(r).ID, teamID, form.Get("title"), form.Get("content"), form.Get("expires"), visibility)
 	if err != nil {
-		app.serverError(w, err)
+		if errors.Is(err, models.ErrNotTeamMember) {
+			form.Errors.Add("team", "You aren't a member of this team")
+			app.renderCreateSnippet(w, r, form)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
+	details := map[string]interface{}{"snippet_id": id, "visibility": visibility}
+	if teamID != 0 {
+		details["team_id"] = teamID
+	}
+	app.audit(r, app.authenticatedUser(r).ID, models.AuditSnippetCreate, details)
+	app.metrics.snippetsCreated.Inc(visibility)
 
 	// Use the Put() method to add a string value ("Your snippet was saved
 	// successfully!") and the corresponding key ("flash") to the session
@@ -134,17 +245,19 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 
 	// Try to create a new user record in the database. If the email already exists
 	// add an error message to the form and re-display it.
-	err = app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	err = app.userModel(r).Insert(form.Get("name"), form.Get("email"), form.Get("password"))
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.Errors.Add("email", "Address is already in use")
 			app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	app.audit(r, 0, models.AuditSignup, map[string]interface{}{"email": form.Get("email")})
+
 	// Otherwise add a confirmation flash message to the session confirming that
 	// their signup worked and asking them to log in.
 	app.session.Put(r, "flash", "Your signup was successful. Please log in.")
@@ -166,36 +279,1430 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Before checking the password (which costs a bcrypt comparison) make sure
+	// that the client hasn't made too many failed attempts recently. The counter
+	// is kept for the email as typed in, whether or not such an account exists,
+	// so the message doesn't reveal anything about the registered users.
+	form := forms.New(r.PostForm)
+	key := "email:" + strings.ToLower(form.Get("email"))
+	throttled, err := app.loginThrottled(r, key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if throttled {
+		form.Errors.Add("generic", "Too many failed login attempts. Please try again later")
+		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+		return
+	}
+
 	// Check whether the credentials are valid. If they're not, add a generic error
 	// message to the form failures map and re-display the login page.
-	form := forms.New(r.PostForm)
-	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
+	id, err := app.userModel(r).Authenticate(form.Get("email"), form.Get("password"))
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.audit(r, 0, models.AuditLoginFailed, map[string]interface{}{"email": form.Get("email")})
+			err = app.loginFailed(r, key)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
 			form.Errors.Add("generic", "Email or Password is incorrect")
 			app.render(w, r, "login.page.tmpl", &templateData{Form: form})
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.accountGuard.Reset(key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	user, err := app.userModel(r).Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// If the user has turned on two-factor authentication, the password alone is
+	// not enough. completeLogin remembers who has passed the first step (and when)
+	// and asks for a TOTP code. The user is not 'logged in' until the code is checked.
+	app.completeLogin(w, r, user, "password", form.Get("remember") != "")
+}
+
+// The oidcLogin handler starts the authorization code flow with PKCE: it
+// remembers the state, the nonce and the code verifier in the session and
+// redirects the user to the identity provider.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	p := app.oidcProvider(r.URL.Query().Get(":provider"))
+	if p == nil {
+		app.notFound(w)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := p.AuthCodeURL(app.oidcRedirectURI(p), state, nonce, verifier)
+	if err != nil {
+		app.requestLogger(r).Error("OpenID Connect authorization URL", "provider", p.Name, "error", err)
+		app.session.Put(r, "flash", fmt.Sprintf("Login with %s is not available at the moment.", p.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.session.Put(r, "oidcProvider", p.Name)
+	app.session.Put(r, "oidcState", state)
+	app.session.Put(r, "oidcNonce", nonce)
+	app.session.Put(r, "oidcVerifier", verifier)
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// The oidcCallback handler receives the user back from the identity provider,
+// exchanges the code for an ID token and logs in the user linked to the identity.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	p := app.oidcProvider(r.URL.Query().Get(":provider"))
+	if p == nil {
+		app.notFound(w)
+		return
+	}
+
+	// The values are single-use, so we pop them from the session straight away.
+	// A missing or different state means that the request wasn't started by
+	// this browser (for example, a login CSRF attack), so we refuse it.
+	provider := app.session.PopString(r, "oidcProvider")
+	state := app.session.PopString(r, "oidcState")
+	nonce := app.session.PopString(r, "oidcNonce")
+	verifier := app.session.PopString(r, "oidcVerifier")
+	q := r.URL.Query()
+	if provider != p.Name || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if q.Get("error") != "" {
+		app.session.Put(r, "flash", fmt.Sprintf("Login with %s has been cancelled.", p.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := p.Exchange(q.Get("code"), app.oidcRedirectURI(p), verifier, nonce)
+	if err != nil {
+		app.requestLogger(r).Error("OpenID Connect login", "provider", p.Name, "error", err)
+		app.session.Put(r, "flash", fmt.Sprintf("Login with %s has failed.", p.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.linkIdentity(r, claims)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			app.session.Put(r, "flash", fmt.Sprintf("%s hasn't confirmed your email address, so we can't log you in with it.", p.DisplayName))
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if !user.Active {
+		app.session.Put(r, "flash", fmt.Sprintf("Login with %s has failed.", p.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.completeLogin(w, r, user, "oidc:"+p.Name, false)
+}
+
+// The linkIdentity helper returns the user linked to the identity from the ID token.
+// On the first login with the identity it is linked to the user with the same email
+// address, or a new user is created. Either way the provider must have verified the
+// address, otherwise anybody could take over an account by claiming its email.
+func (app *application) linkIdentity(r *http.Request, claims *oidc.Claims) (*models.User, error) {
+	id, err := app.identities.Get(claims.Issuer, claims.Subject)
+	if err == nil {
+		return app.userModel(r).Get(id)
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := app.userModel(r).GetByEmail(claims.Email)
+	if errors.Is(err, models.ErrNoRecord) {
+		// The new user logs in with the identity provider only, so they get a random
+		// password which nobody knows. They can set their own one with "forgot password".
+		password, err := oidc.RandomString()
+		if err != nil {
+			return nil, err
+		}
+		name := claims.Name
+		if name == "" {
+			name = claims.Email
+		}
+		err = app.userModel(r).Insert(name, claims.Email, password)
+		if err != nil {
+			return nil, err
+		}
+		user, err = app.userModel(r).GetByEmail(claims.Email)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = app.identities.Insert(claims.Issuer, claims.Subject, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (app *application) loginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if !app.session.Exists(r, "twoFactorUserID") {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	app.render(w, r, "login2fa.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// The first step of the login must have been passed recently, otherwise the
+	// user has to start again with their password.
+	started := time.Unix(int64(app.session.GetInt(r, "twoFactorStarted")), 0)
+	if !app.session.Exists(r, "twoFactorUserID") || time.Since(started) > twoFactorLoginTTL {
+		app.session.Remove(r, "twoFactorUserID")
+		app.session.Remove(r, "twoFactorStarted")
+		app.session.Remove(r, "twoFactorRemember")
+		app.session.Put(r, "flash", "Your login has expired. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Six digits are easy to guess, so the second step is protected from
+	// brute-force attacks in the same way as the password.
+	id := app.session.GetInt(r, "twoFactorUserID")
+	key := fmt.Sprintf("user:%d", id)
+	throttled, err := app.loginThrottled(r, key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if throttled {
+		form.Errors.Add("generic", "Too many failed login attempts. Please try again later")
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// A six digit code comes from the authenticator app, anything else is
+	// treated as one of the recovery codes.
+	code := strings.ReplaceAll(form.Get("code"), " ", "")
+	if totpCodeRX.MatchString(code) {
+		err = app.userModel(r).AuthenticateTOTP(id, code)
+	} else {
+		err = app.userModel(r).AuthenticateRecoveryCode(id, code)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.audit(r, id, models.AuditLoginFailed, map[string]interface{}{"method": "2fa"})
+			err = app.loginFailed(r, key)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.Errors.Add("generic", "The code is incorrect")
+			app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		} else {
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now 'logged // in'.
-	app.session.Put(r, "authenticatedUserID", id)
+	err = app.accountGuard.Reset(key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	user, err := app.userModel(r).Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	remember := app.session.GetBool(r, "twoFactorRemember")
+	app.session.Remove(r, "twoFactorUserID")
+	app.session.Remove(r, "twoFactorStarted")
+	app.session.Remove(r, "twoFactorRemember")
+	err = app.logIn(w, r, user, remember)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, models.AuditLogin, map[string]interface{}{"method": "2fa"})
 
-	// Redirect the user to the create snippet page.
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the authenticatedUserID from the session data so that the user is 'logged out'
-	app.session.Remove(r, "authenticatedUserID")
+	// Delete the server-side session, so that the token can't be used any more
+	// (even if somebody has copied the cookie), and remove it from the cookie
+	// so that the user is 'logged out'.
+	if s := app.loginSession(r); s != nil {
+		err := app.loginSessions.Revoke(s.UserID, s.ID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		app.audit(r, s.UserID, models.AuditLogout, nil)
+	}
+	app.session.Remove(r, "sessionToken")
+	app.clearRememberCookie(w)
 
 	// Add a flash message to the session to confirm to the user that they've been logged out
 	app.session.Put(r, "flash", "You've been logged out successfully!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func ping(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("OK"))
-}
\ No newline at end of file
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "forgot.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.render(w, r, "forgot.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// The response must look exactly the same whether or not an account with this
+	// email exists, otherwise the form could be used to find out who is registered.
+	// That's why the token is created and the email is sent in the background: the
+	// time it takes to respond doesn't depend on the email address either.
+	//
+	// The task outlives the request, so it mustn't use r: the logger is taken
+	// beforehand, and the query goes to the model directly instead of becoming a
+	// span of the finished request.
+	email := form.Get("email")
+	logger := app.requestLogger(r)
+	app.background(func() {
+		user, err := app.users.GetByEmail(email)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				logger.Error("password reset", "error", err)
+			}
+			return
+		}
+		if !user.Active {
+			return
+		}
+
+		token, err := app.tokens.New(user.ID, passwordResetTTL, models.ScopePasswordReset, "")
+		if err != nil {
+			logger.Error("password reset", "error", err)
+			return
+		}
+
+		body := fmt.Sprintf("Hi %s,\n\nTo reset your Snippetbox password please follow the link below. "+
+			"It expires in %d minutes and can be used only once.\n\n%s/user/password/reset?token=%s\n\n"+
+			"If you didn't ask to reset your password, you can safely ignore this email.\n",
+			user.Name, int(passwordResetTTL.Minutes()), app.baseURL, token)
+		err = app.mailer.Send(user.Email, "Reset your Snippetbox password", body)
+		if err != nil {
+			logger.Error("password reset email", "error", err)
+		}
+	})
+
+	app.session.Put(r, "flash", "If an account with that email exists, we've sent a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "reset.page.tmpl", &templateData{
+		Form: forms.New(url.Values{"token": []string{r.URL.Query().Get("token")}}),
+	})
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The new password is validated with the same rules as on signup.
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", 10)
+	if !form.Valid() {
+		app.render(w, r, "reset.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Use up the token. If it doesn't exist, has expired or has already been used,
+	// re-display the form with a generic error message.
+	token, err := app.tokens.Consume(form.Get("token"), models.ScopePasswordReset)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			form.Errors.Add("generic", "This password reset link is invalid or has expired")
+			app.render(w, r, "reset.page.tmpl", &templateData{Form: form})
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// UpdatePassword also deletes all the sessions of the user, so everybody who has
+	// logged in with the old password (maybe an attacker) is logged out.
+	err = app.userModel(r).UpdatePassword(token.UserID, form.Get("password"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, token.UserID, models.AuditPasswordReset, nil)
+
+	app.session.Put(r, "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	sessions, err := app.loginSessions.List(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, "account.page.tmpl", &templateData{
+		LoginSession:  app.loginSession(r),
+		LoginSessions: sessions,
+		User:          user,
+	})
+}
+
+// The revokeSession handler logs out one of the sessions of the current user,
+// for example on a lost phone. If it is the current session, the user is logged out here.
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Requests authenticated with a client certificate have no current session.
+	current := app.loginSession(r)
+	err = app.loginSessions.Revoke(app.authenticatedUser(r).ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if current != nil && id == current.ID {
+		app.session.Remove(r, "sessionToken")
+		app.clearRememberCookie(w)
+		app.session.Put(r, "flash", "You've been logged out successfully!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.session.Put(r, "flash", "The session has been logged out.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// The revokeOtherSessions handler logs out all the sessions of the current user except this one.
+func (app *application) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	// Without a current session (with a client certificate) all sessions are logged out.
+	currentID := 0
+	if current := app.loginSession(r); current != nil {
+		currentID = current.ID
+	}
+	err := app.loginSessions.RevokeOthers(app.authenticatedUser(r).ID, currentID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "All your other sessions have been logged out.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) updateNameForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "name.page.tmpl", &templateData{
+		Form: forms.New(url.Values{"name": []string{app.authenticatedUser(r).Name}}),
+	})
+}
+
+func (app *application) updateName(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 255)
+	if !form.Valid() {
+		app.render(w, r, "name.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	err = app.userModel(r).UpdateName(app.authenticatedUser(r).ID, form.Get("name"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your name has been updated.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) updateEmailForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "email.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) updateEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.render(w, r, "email.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Check up front whether the address is taken, in the same way as the
+	// signup form does. It can still be taken before the change is confirmed,
+	// so confirmEmail handles models.ErrDuplicateEmail as well.
+	user := app.authenticatedUser(r)
+	email := form.Get("email")
+	_, err = app.userModel(r).GetByEmail(email)
+	if err == nil {
+		form.Errors.Add("email", "Address is already in use")
+		app.render(w, r, "email.page.tmpl", &templateData{Form: form})
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The email isn't changed until the user proves that they own the new
+	// address, by following the link which we send to it.
+	token, err := app.tokens.New(user.ID, emailChangeTTL, models.ScopeEmailChange, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	logger := app.requestLogger(r)
+	app.background(func() {
+		body := fmt.Sprintf("Hi %s,\n\nPlease follow the link below to confirm that you want to use this "+
+			"address for your Snippetbox account. The link expires in %d hours.\n\n%s/account/email/confirm?token=%s\n",
+			user.Name, int(emailChangeTTL.Hours()), app.baseURL, token)
+		err := app.mailer.Send(email, "Confirm your new email address", body)
+		if err != nil {
+			logger.Error("email change confirmation", "error", err)
+		}
+	})
+
+	app.session.Put(r, "flash", "We've sent a confirmation link to your new email address.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	token, err := app.tokens.Consume(r.URL.Query().Get("token"), models.ScopeEmailChange)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.session.Put(r, "flash", "This confirmation link is invalid or has expired.")
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the user before the change, so that we know the old address.
+	user, err := app.userModel(r).Get(token.UserID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.userModel(r).UpdateEmail(user.ID, token.Data)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.session.Put(r, "flash", "This address is already used by another account.")
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Let the owner of the old address know about the change, in case it wasn't them.
+	logger := app.requestLogger(r)
+	app.background(func() {
+		body := fmt.Sprintf("Hi %s,\n\nThe email address of your Snippetbox account has been changed to %s.\n\n"+
+			"If you didn't do this, please reset your password and contact us.\n", user.Name, token.Data)
+		err := app.mailer.Send(user.Email, "Your email address has been changed", body)
+		if err != nil {
+			logger.Error("email change notification", "error", err)
+		}
+	})
+
+	app.session.Put(r, "flash", "Your email address has been updated.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) updatePasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "password.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) updatePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("currentPassword", "newPassword")
+	form.MinLength("newPassword", 10)
+	if !form.Valid() {
+		app.render(w, r, "password.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Require the current password, so that somebody who has got hold of an
+	// open session can't lock the owner out of their account. The guesses share
+	// the counter of the login form, otherwise this form could be used to find
+	// out the password without any limit.
+	user := app.authenticatedUser(r)
+	key := "email:" + strings.ToLower(user.Email)
+	throttled, err := app.loginThrottled(r, key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if throttled {
+		form.Errors.Add("currentPassword", "Too many failed attempts. Please try again later")
+		app.render(w, r, "password.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	_, err = app.userModel(r).Authenticate(user.Email, form.Get("currentPassword"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, key)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.Errors.Add("currentPassword", "Current password is incorrect")
+			app.render(w, r, "password.page.tmpl", &templateData{Form: form})
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.accountGuard.Reset(key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.userModel(r).UpdatePassword(user.ID, form.Get("newPassword"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, models.AuditPasswordChange, nil)
+
+	// Changing the password revokes every session of the user, including
+	// the current one. Start a new session, so that the user stays logged in here
+	// (and is still remembered, if they were before).
+	_, rememberErr := r.Cookie(rememberCookieName)
+	err = app.logIn(w, r, user, rememberErr == nil)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your password has been changed.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) twoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user.TwoFactorEnabled {
+		app.render(w, r, "2fa.page.tmpl", &templateData{Form: forms.New(nil), User: user})
+		return
+	}
+
+	// Generate a new secret for the enrolment and keep it in the session until
+	// the user proves that they have set up their authenticator app correctly.
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		var err error
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.session.Put(r, "pendingTOTPSecret", secret)
+	}
+
+	app.render(w, r, "2fa.page.tmpl", &templateData{
+		Form:       forms.New(nil),
+		TOTPSecret: secret,
+		TOTPURI:    totp.URI(totpIssuer, user.Email, secret),
+		User:       user,
+	})
+}
+
+// The twoFactorQRCode handler renders the provisioning URI of the pending TOTP
+// secret as a PNG image, so that it can be scanned with an authenticator app.
+func (app *application) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+
+	code, err := qr.Encode(totp.URI(totpIssuer, app.authenticatedUser(r).Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	code.Scale = 6
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
+}
+
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" || user.TwoFactorEnabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if form.Valid() {
+		if _, ok := totp.Validate(secret, form.Get("code"), time.Now()); !ok {
+			form.Errors.Add("code", "The code is incorrect")
+		}
+	}
+	if !form.Valid() {
+		app.render(w, r, "2fa.page.tmpl", &templateData{
+			Form:       form,
+			TOTPSecret: secret,
+			TOTPURI:    totp.URI(totpIssuer, user.Email, secret),
+			User:       user,
+		})
+		return
+	}
+
+	codes, err := totp.RecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.userModel(r).EnableTOTP(user.ID, secret, codes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.session.Remove(r, "pendingTOTPSecret")
+
+	// The recovery codes are shown only once, right now. That's why we render
+	// the page here instead of redirecting.
+	app.render(w, r, "2fa.page.tmpl", &templateData{
+		RecoveryCodes: codes,
+		User:          user,
+	})
+}
+
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The password is checked with the same brute-force protection as the login.
+	user := app.authenticatedUser(r)
+	key := "email:" + strings.ToLower(user.Email)
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	if form.Valid() {
+		throttled, err := app.loginThrottled(r, key)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if throttled {
+			form.Errors.Add("password", "Too many failed attempts. Please try again later")
+		}
+	}
+	if form.Valid() {
+		_, err = app.userModel(r).Authenticate(user.Email, form.Get("password"))
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, key)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.Errors.Add("password", "Password is incorrect")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		app.render(w, r, "2fa.page.tmpl", &templateData{Form: form, User: user})
+		return
+	}
+
+	err = app.accountGuard.Reset(key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.userModel(r).DisableTOTP(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Two-factor authentication has been turned off.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// The userProfile handler shows the public profile of a user: their name, when
+// they joined and their public snippets which haven't expired, page by page.
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	// Deactivated users don't have a profile any more.
+	user, err := app.userModel(r).Get(id)
+	if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.notFound(w)
+			return
+		}
+	}
+
+	// Fetch one snippet more than we show, to find out whether there is a next page.
+	snippets, err := app.snippetModel(r).PublicByUser(user.ID, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if page > 1 && len(snippets) == 0 {
+		app.notFound(w)
+		return
+	}
+
+	p := &pagination{Page: page}
+	if page > 1 {
+		p.Prev = page - 1
+	}
+	if len(snippets) > profilePageSize {
+		snippets = snippets[:profilePageSize]
+		p.Next = page + 1
+	}
+
+	app.render(w, r, "profile.page.tmpl", &templateData{
+		Pagination: p,
+		Snippets:   snippets,
+		User:       user,
+	})
+}
+
+// The myProfile handler redirects the current user to their own profile.
+func (app *application) myProfile(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, fmt.Sprintf("/user/%d", app.authenticatedUser(r).ID), http.StatusSeeOther)
+}
+
+// The adminDashboard handler shows the counts of users and snippets, and the
+// most recent of them with the actions available to the current user's role.
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	c := &counts{}
+	var err error
+	c.Users, c.ActiveUsers, err = app.userModel(r).Count()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	c.Snippets, c.LiveSnippets, err = app.snippetModel(r).Count()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	users, err := app.userModel(r).List(adminListLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	snippets, err := app.snippetModel(r).Recent(adminListLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, "admin.page.tmpl", &templateData{
+		Counts:   c,
+		Snippets: snippets,
+		Users:    users,
+	})
+}
+
+// The adminDeleteSnippet handler deletes any snippet. It is available to moderators.
+func (app *application) adminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippetModel(r).Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.audit(r, app.authenticatedUser(r).ID, models.AuditSnippetDelete, map[string]interface{}{"snippet_id": id})
+
+	app.session.Put(r, "flash", fmt.Sprintf("Snippet #%d has been deleted.", id))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// The adminSetUserActive handler deactivates a user (or activates them again).
+// Deactivated users can't log in, and their sessions stop working straight away,
+// because the authenticate middleware checks the user on every request.
+func (app *application) adminSetUserActive(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	active := r.PostForm.Get("active") == "true"
+	err := app.userModel(r).SetActive(user.ID, active)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	action := models.AuditUserDeactivate
+	if active {
+		action = models.AuditUserActivate
+	}
+	app.audit(r, app.authenticatedUser(r).ID, action, map[string]interface{}{"user_id": user.ID})
+
+	if active {
+		app.session.Put(r, "flash", fmt.Sprintf("%s has been activated.", user.Name))
+	} else {
+		app.session.Put(r, "flash", fmt.Sprintf("%s has been deactivated.", user.Name))
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// The adminSetUserRole handler changes the role of a user.
+func (app *application) adminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	role := r.PostForm.Get("role")
+	if !models.ValidRole(role) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := app.userModel(r).SetRole(user.ID, role)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, app.authenticatedUser(r).ID, models.AuditUserRoleChange, map[string]interface{}{"user_id": user.ID, "from": user.Role, "to": role})
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s is now a %s.", user.Name, role))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// The adminAuditLog handler shows the most recent events of the audit log.
+func (app *application) adminAuditLog(w http.ResponseWriter, r *http.Request) {
+	events, err := app.auditEvents.Recent(auditListLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, "audit.page.tmpl", &templateData{
+		AuditEvents: events,
+	})
+}
+
+// The adminAuditExport handler sends the whole audit log as JSON lines, one event
+// per line, the oldest first. The events are streamed, so the log can be large.
+// The export itself is recorded too.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	app.audit(r, app.authenticatedUser(r).ID, models.AuditExport, nil)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102")))
+
+	// json.Encoder writes a newline after every value, which is exactly the format we need.
+	enc := json.NewEncoder(w)
+	err := app.auditEvents.Each(func(e *models.AuditEvent) error {
+		return enc.Encode(e)
+	})
+	if err != nil {
+		// The headers (and maybe a part of the log) have already been sent, so
+		// the best we can do is to log the error.
+		app.requestLogger(r).Error("audit log export", "error", err)
+	}
+}
+
+// The adminTargetUser helper parses the form of a user action and returns the user
+// it is applied to. Administrators can't apply the actions to themselves, so that
+// they can't lock themselves (or the last administrator) out by accident. If the
+// request is invalid, the response is written and ok is false.
+func (app *application) adminTargetUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	if id == app.authenticatedUser(r).ID {
+		app.session.Put(r, "flash", "You can't change your own account here.")
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return nil, false
+	}
+
+	user, err := app.userModel(r).Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+func (app *application) listTeams(w http.ResponseWriter, r *http.Request) {
+	app.renderTeams(w, r, forms.New(nil))
+}
+
+// The renderTeams helper shows the teams of the current user and the form for a new team.
+func (app *application) renderTeams(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	teams, err := app.teams.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, "teams.page.tmpl", &templateData{
+		Form:  form,
+		Teams: teams,
+	})
+}
+
+func (app *application) createTeam(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 100)
+	if !form.Valid() {
+		app.renderTeams(w, r, form)
+		return
+	}
+
+	id, err := app.teams.Create(app.authenticatedUser(r).ID, form.Get("name"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Team successfully created!")
+	http.Redirect(w, r, fmt.Sprintf("/team/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) showTeam(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	app.renderTeam(w, r, id, forms.New(nil))
+}
+
+// The renderTeam helper shows the team page: the members of the team, its snippets
+// and, for the owners, the invitation form. Teams which the user isn't a member of
+// look exactly like missing ones.
+func (app *application) renderTeam(w http.ResponseWriter, r *http.Request, id int, form *forms.Form) {
+	user := app.authenticatedUser(r)
+
+	team, err := app.teams.Get(user.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotTeamMember) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	members, err := app.teams.Members(user.ID, team.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.notFound(w)
+			return
+		}
+	}
+
+	// Fetch one snippet more than we show, to find out whether there is a next page.
+	snippets, err := app.snippetModel(r).ByTeam(user.ID, team.ID, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if page > 1 && len(snippets) == 0 {
+		app.notFound(w)
+		return
+	}
+
+	p := &pagination{Page: page}
+	if page > 1 {
+		p.Prev = page - 1
+	}
+	if len(snippets) > profilePageSize {
+		snippets = snippets[:profilePageSize]
+		p.Next = page + 1
+	}
+
+	app.render(w, r, "team.page.tmpl", &templateData{
+		Form:       form,
+		Members:    members,
+		Pagination: p,
+		Snippets:   snippets,
+		Team:       team,
+	})
+}
+
+func (app *application) inviteTeamMember(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.renderTeam(w, r, id, form)
+		return
+	}
+
+	// The model checks that only the owners of the team can invite.
+	user := app.authenticatedUser(r)
+	email := form.Get("email")
+	token, err := app.teams.Invite(user.ID, id, email, teamInvitationTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotTeamMember):
+			app.notFound(w)
+		case errors.Is(err, models.ErrNotTeamOwner):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	team, err := app.teams.Get(user.ID, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	logger := app.requestLogger(r)
+	app.background(func() {
+		body := fmt.Sprintf("Hi,\n\n%s has invited you to join the team %q on Snippetbox. "+
+			"To accept the invitation, log in with this email address and follow the link below. "+
+			"It expires in %d days.\n\n%s/team/invitation?token=%s\n\n"+
+			"If you don't want to join the team, you can safely ignore this email.\n",
+			user.Name, team.Name, int(teamInvitationTTL.Hours()/24), app.baseURL, url.QueryEscape(token))
+		err := app.mailer.Send(email, "You've been invited to a Snippetbox team", body)
+		if err != nil {
+			logger.Error("team invitation email", "team_id", team.ID, "error", err)
+		}
+	})
+
+	app.session.Put(r, "flash", fmt.Sprintf("An invitation has been sent to %s.", email))
+	http.Redirect(w, r, fmt.Sprintf("/team/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) teamInvitationForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	invitation, err := app.teams.Invitation(token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	form := forms.New(url.Values{"token": []string{token}})
+	app.render(w, r, "invitation.page.tmpl", &templateData{
+		Form:       form,
+		Invitation: invitation,
+	})
+}
+
+func (app *application) acceptTeamInvitation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The invitation can only be accepted by the account with the email address
+	// which it has been sent to.
+	user := app.authenticatedUser(r)
+	id, err := app.teams.Accept(r.PostForm.Get("token"), user.ID, user.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.session.Put(r, "flash", "The invitation is invalid, has expired or has been sent to another email address.")
+			http.Redirect(w, r, "/teams", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "Welcome to the team!")
+	http.Redirect(w, r, fmt.Sprintf("/team/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) leaveTeam(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.teams.Leave(app.authenticatedUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotTeamMember):
+			app.notFound(w)
+		case errors.Is(err, models.ErrLastTeamOwner):
+			app.session.Put(r, "flash", "You are the only owner of the team, so you can't leave it.")
+			http.Redirect(w, r, fmt.Sprintf("/team/%d", id), http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "You have left the team.")
+	http.Redirect(w, r, "/teams", http.StatusSeeOther)
+}
+
+func (app *application) removeTeamMember(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	memberID, err := strconv.Atoi(r.PostForm.Get("user"))
+	if err != nil || memberID < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The model checks that only the owners of the team can remove members.
+	err = app.teams.RemoveMember(app.authenticatedUser(r).ID, id, memberID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotTeamMember), errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, models.ErrNotTeamOwner):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "The member has been removed from the team.")
+	http.Redirect(w, r, fmt.Sprintf("/team/%d", id), http.StatusSeeOther)
+}
+
+// The cspReport handler logs the reports which browsers send when a page violates
+// the Content Security Policy. Anybody can send a report, so the size of the
+// request is limited.
+func (app *application) cspReport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cspReportMaxBytes)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	violations, err := parseCSPReports(body)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	for _, v := range violations {
+		app.requestLogger(r).Warn("CSP violation", "directive", v.Directive, "blocked_uri", v.BlockedURI, "document_uri", v.DocumentURI, "ip", clientIP(r))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The ping handler is used by the load balancer. While the application shuts down,
+// it responds with 503, so that the load balancer stops sending new requests here
+// before the server closes its listener.
+func (app *application) ping(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&app.draining) == 1 {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("OK"))
+}
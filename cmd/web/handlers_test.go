@@ -2,8 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/models/mock"
+	"github.com/Dimau/snippetbox/pkg/oidc"
+	"github.com/Dimau/snippetbox/pkg/oidc/oidctest"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +52,8 @@ func TestShowSnippet(t *testing.T) {
 		{"String ID", "/snippet/foo", http.StatusNotFound, nil},
 		{"Empty ID", "/snippet/", http.StatusNotFound, nil},
 		{"Trailing slash", "/snippet/1/", http.StatusNotFound, nil},
+		{"Unlisted snippet", "/snippet/4", http.StatusOK, []byte("Only for friends...")},
+		{"Private snippet of another user", "/snippet/3", http.StatusNotFound, nil},
 	}
 
 	for _, tt := range tests {
@@ -60,6 +69,51 @@ func TestShowSnippet(t *testing.T) {
 	}
 }
 
+func TestShowSnippetETag(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	get := func(ifNoneMatch string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		return rs
+	}
+
+	rs := get("")
+	etag := rs.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("want an ETag")
+	}
+	if cc := rs.Header.Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("want Cache-Control %q; got %q", "private, no-cache", cc)
+	}
+
+	// The page is the same, even though the CSP nonce is new.
+	rs = get(etag)
+	if rs.StatusCode != http.StatusNotModified {
+		t.Errorf("want %d; got %d", http.StatusNotModified, rs.StatusCode)
+	}
+	if csp := rs.Header.Get("Content-Security-Policy"); csp != "" {
+		t.Errorf("want no Content-Security-Policy in the 304 response; got %q", csp)
+	}
+
+	rs = get(`"0123456789abcdef"`)
+	if rs.StatusCode != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, rs.StatusCode)
+	}
+}
+
 func TestSignupUser(t *testing.T) {
 	// Create the application struct containing our mocked dependencies and set
 	// up the test server for running and end-to-end test.
@@ -110,3 +164,1122 @@ func TestSignupUser(t *testing.T) {
 		})
 	}
 }
+
+func TestForgotPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	mailer := app.mailer.(*testMailer)
+
+	tests := []struct {
+		name      string
+		userEmail string
+		wantCode  int
+		wantBody  []byte
+		wantSent  int
+	}{
+		// Existing and unknown emails must get exactly the same response.
+		{"Existing email", "alice@example.com", http.StatusSeeOther, nil, 1},
+		{"Unknown email", "bob@example.com", http.StatusSeeOther, nil, 0},
+		{"Empty email", "", http.StatusOK, []byte("This field cannot be blank"), 0},
+		{"Invalid email", "bobexample.com", http.StatusOK, []byte("This field is invalid"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailer.mu.Lock()
+			mailer.sent = nil
+			mailer.mu.Unlock()
+
+			form := url.Values{}
+			form.Add("email", tt.userEmail)
+			code, header, body := ts.postForm(t, "/user/password/forgot", form)
+
+			// The email is sent in the background.
+			app.wg.Wait()
+			mailer.mu.Lock()
+			sent := mailer.sent
+			mailer.mu.Unlock()
+			if len(sent) != tt.wantSent {
+				t.Errorf("want %d emails sent; got %q", tt.wantSent, sent)
+			}
+			if tt.wantSent > 0 && sent[0] != tt.userEmail {
+				t.Errorf("want email sent to %q; got %q", tt.userEmail, sent[0])
+			}
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if code == http.StatusSeeOther && header.Get("Location") != "/user/login" {
+				t.Errorf("want redirect to %q; got %q", "/user/login", header.Get("Location"))
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		token        string
+		userPassword string
+		wantCode     int
+		wantBody     []byte
+	}{
+		{"Valid submission", "VALIDTOKEN", "validPa$$word", http.StatusSeeOther, nil},
+		{"Invalid token", "WRONGTOKEN", "validPa$$word", http.StatusOK, []byte("This password reset link is invalid or has expired")},
+		{"Empty password", "VALIDTOKEN", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Short password", "VALIDTOKEN", "pa$$word", http.StatusOK, []byte("This field is too short")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("token", tt.token)
+			form.Add("password", tt.userPassword)
+			code, _, body := ts.postForm(t, "/user/password/reset", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Anonymous users are redirected to the login page.
+	code, header, _ := ts.get(t, "/account")
+	if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+		t.Errorf("want redirect to %q; got %d %q", "/user/login", code, header.Get("Location"))
+	}
+
+	ts.logIn(t)
+	code, _, body := ts.get(t, "/account")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("alice@example.com")) {
+		t.Errorf("want body %s to contain %q", body, "alice@example.com")
+	}
+}
+
+func TestUpdateEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.logIn(t)
+
+	tests := []struct {
+		name      string
+		userEmail string
+		wantCode  int
+		wantBody  []byte
+	}{
+		{"Valid submission", "alice@new.example.com", http.StatusSeeOther, nil},
+		{"Empty email", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Invalid email", "bobexample.com", http.StatusOK, []byte("This field is invalid")},
+		{"Duplicate email", "dupe@example.com", http.StatusOK, []byte("Address is already in use")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.userEmail)
+			code, _, body := ts.postForm(t, "/account/email", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestConfirmEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.logIn(t)
+
+	tests := []struct {
+		name      string
+		token     string
+		wantFlash []byte
+	}{
+		{"Valid token", "VALIDTOKEN", []byte("Your email address has been updated.")},
+		{"Invalid token", "WRONGTOKEN", []byte("This confirmation link is invalid or has expired.")},
+		{"Duplicate email", "DUPETOKEN", []byte("This address is already used by another account.")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, "/account/email/confirm?token="+tt.token)
+			if code != http.StatusSeeOther {
+				t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			// The result is reported with a flash message on the account page.
+			_, _, body := ts.get(t, "/account")
+			if !bytes.Contains(body, tt.wantFlash) {
+				t.Errorf("want body %s to contain %q", body, tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestUpdatePassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.logIn(t)
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		newPassword     string
+		wantCode        int
+		wantBody        []byte
+	}{
+		{"Valid submission", "validPa$$word", "newValidPa$$word", http.StatusSeeOther, nil},
+		{"Wrong current password", "wrongPa$$word", "newValidPa$$word", http.StatusOK, []byte("Current password is incorrect")},
+		{"Empty new password", "validPa$$word", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Short new password", "validPa$$word", "pa$$word", http.StatusOK, []byte("This field is too short")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("currentPassword", tt.currentPassword)
+			form.Add("newPassword", tt.newPassword)
+			code, _, body := ts.postForm(t, "/account/password", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUpdatePasswordThrottling(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.logIn(t)
+
+	updatePassword := func(currentPassword string) (int, []byte) {
+		form := url.Values{}
+		form.Add("currentPassword", currentPassword)
+		form.Add("newPassword", "newValidPa$$word")
+		code, _, body := ts.postForm(t, "/account/password", form)
+		return code, body
+	}
+
+	for i := 0; i < loginLockoutPolicy.FreeAttempts+1; i++ {
+		_, body := updatePassword("wrongPa$$word")
+		if !bytes.Contains(body, []byte("Current password is incorrect")) {
+			t.Fatalf("want body %s to contain %q", body, "Current password is incorrect")
+		}
+	}
+
+	// Now even the right password is refused for a while, without checking it.
+	code, body := updatePassword("validPa$$word")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("Too many failed attempts")) {
+		t.Errorf("want %d and body %s to contain %q; got %d", http.StatusOK, body, "Too many failed attempts", code)
+	}
+
+	// The login form shares the counter.
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	_, _, body = ts.postForm(t, "/user/login", form)
+	if !bytes.Contains(body, []byte("Too many failed login attempts")) {
+		t.Errorf("want body %s to contain %q", body, "Too many failed login attempts")
+	}
+}
+
+func TestDisableTwoFactorThrottling(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	form := url.Values{}
+	form.Add("email", "carol@example.com")
+	form.Add("password", "validPa$$word")
+	ts.postForm(t, "/user/login", form)
+	form = url.Values{}
+	form.Add("code", "123456")
+	code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	disable := func(password string) (int, []byte) {
+		form := url.Values{}
+		form.Add("password", password)
+		code, _, body := ts.postForm(t, "/account/2fa/disable", form)
+		return code, body
+	}
+
+	for i := 0; i < loginLockoutPolicy.FreeAttempts+1; i++ {
+		_, body := disable("wrongPa$$word")
+		if !bytes.Contains(body, []byte("Password is incorrect")) {
+			t.Fatalf("want body %s to contain %q", body, "Password is incorrect")
+		}
+	}
+
+	// Now even the right password is refused for a while, without checking it.
+	code, body := disable("validPa$$word")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("Too many failed attempts")) {
+		t.Errorf("want %d and body %s to contain %q; got %d", http.StatusOK, body, "Too many failed attempts", code)
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Valid TOTP code", "123456", http.StatusSeeOther, "/snippet/create", nil},
+		{"Valid recovery code", "abcde-fghij", http.StatusSeeOther, "/snippet/create", nil},
+		{"Wrong code", "654321", http.StatusOK, "", []byte("The code is incorrect")},
+		{"Empty code", "", http.StatusOK, "", []byte("This field cannot be blank")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// The password alone must not log in a user with two-factor authentication.
+			form := url.Values{}
+			form.Add("email", "carol@example.com")
+			form.Add("password", "validPa$$word")
+			code, header, _ := ts.postForm(t, "/user/login", form)
+			if code != http.StatusSeeOther || header.Get("Location") != "/user/login/2fa" {
+				t.Fatalf("want redirect to %q; got %d %q", "/user/login/2fa", code, header.Get("Location"))
+			}
+			code, _, _ = ts.get(t, "/account")
+			if code != http.StatusSeeOther {
+				t.Fatalf("want %d before the second step; got %d", http.StatusSeeOther, code)
+			}
+
+			form = url.Values{}
+			form.Add("code", tt.code)
+			code, header, body := ts.postForm(t, "/user/login/2fa", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if header.Get("Location") != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, header.Get("Location"))
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLoginTwoFactorWithoutPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Skipping the first step of the login must not work.
+	form := url.Values{}
+	form.Add("code", "123456")
+	code, header, _ := ts.postForm(t, "/user/login/2fa", form)
+	if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+		t.Errorf("want redirect to %q; got %d %q", "/user/login", code, header.Get("Location"))
+	}
+}
+
+func TestTwoFactorEnrolment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.logIn(t)
+
+	code, _, body := ts.get(t, "/account/2fa")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("otpauth://totp/Snippetbox:alice@example.com")) {
+		t.Errorf("want body %s to contain the provisioning URI", body)
+	}
+
+	code, header, body := ts.get(t, "/account/2fa/qr.png")
+	if code != http.StatusOK || header.Get("Content-Type") != "image/png" {
+		t.Errorf("want a PNG image; got %d %q", code, header.Get("Content-Type"))
+	}
+	if !bytes.HasPrefix(body, []byte("\x89PNG")) {
+		t.Errorf("want body to be a PNG image")
+	}
+
+	form := url.Values{}
+	form.Add("code", "000000")
+	code, _, body = ts.postForm(t, "/account/2fa", form)
+	if code != http.StatusOK || !bytes.Contains(body, []byte("The code is incorrect")) {
+		t.Errorf("want the wrong code to be rejected; got %d %s", code, body)
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	login := func(email, password string) []byte {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", password)
+		_, _, body := ts.postForm(t, "/user/login", form)
+		return body
+	}
+
+	// The first failures only get the usual generic message.
+	for i := 0; i < loginLockoutPolicy.FreeAttempts+1; i++ {
+		body := login("alice@example.com", "wrongPa$$word")
+		if !bytes.Contains(body, []byte("Email or Password is incorrect")) {
+			t.Fatalf("want body %s to contain %q", body, "Email or Password is incorrect")
+		}
+	}
+
+	// Now even the right password is refused for a while, without checking it.
+	body := login("alice@example.com", "validPa$$word")
+	if !bytes.Contains(body, []byte("Too many failed login attempts")) {
+		t.Errorf("want body %s to contain %q", body, "Too many failed login attempts")
+	}
+
+	// Unknown accounts are throttled in exactly the same way.
+	for i := 0; i < loginLockoutPolicy.FreeAttempts+1; i++ {
+		login("nobody@example.com", "wrongPa$$word")
+	}
+	body = login("nobody@example.com", "wrongPa$$word")
+	if !bytes.Contains(body, []byte("Too many failed login attempts")) {
+		t.Errorf("want body %s to contain %q", body, "Too many failed login attempts")
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name          string
+		subject       string
+		email         string
+		emailVerified bool
+		wantLocation  string
+		wantFlash     []byte
+	}{
+		{"Known identity", "alice-subject", "someone@example.com", false, "/snippet/create", nil},
+		{"Link by verified email", "248289761001", "alice@example.com", true, "/snippet/create", nil},
+		{"Unverified email", "248289761001", "alice@example.com", false, "/user/login", []byte("hasn&#39;t confirmed your email address")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := oidctest.NewServer("snippetbox", "s3cret")
+			defer idp.Close()
+			idp.Subject, idp.Email, idp.EmailVerified = tt.subject, tt.email, tt.emailVerified
+
+			app := newTestApplication(t)
+			app.oidcProviders = []*oidc.Provider{oidc.NewProvider(oidc.Config{
+				Name:         "test",
+				DisplayName:  "Test",
+				Issuer:       idp.Issuer(),
+				ClientID:     "snippetbox",
+				ClientSecret: "s3cret",
+			}, idp.Client())}
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// The login page links to the provider.
+			_, _, body := ts.get(t, "/user/login")
+			if !bytes.Contains(body, []byte("/user/login/oidc/test")) {
+				t.Fatalf("want body %s to contain a link to the provider", body)
+			}
+
+			// The application redirects to the provider, which immediately redirects
+			// back to the callback (on the base URL of the application).
+			code, header, _ := ts.get(t, "/user/login/oidc/test")
+			if code != http.StatusSeeOther {
+				t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			client := idp.Client()
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+			rs, err := client.Get(header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+			callback, err := url.Parse(rs.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callback.Path != "/user/login/oidc/test/callback" {
+				t.Fatalf("want callback path %q; got %q", "/user/login/oidc/test/callback", callback.Path)
+			}
+
+			code, header, _ = ts.get(t, callback.RequestURI())
+			if code != http.StatusSeeOther {
+				t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			if header.Get("Location") != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, header.Get("Location"))
+			}
+			if tt.wantFlash != nil {
+				_, _, body = ts.get(t, "/user/login")
+				if !bytes.Contains(body, tt.wantFlash) {
+					t.Errorf("want body %s to contain %q", body, tt.wantFlash)
+				}
+			}
+
+			// The state is single-use, so the callback can't be replayed.
+			code, _, _ = ts.get(t, callback.RequestURI())
+			if code != http.StatusBadRequest {
+				t.Errorf("want %d on replay; got %d", http.StatusBadRequest, code)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackWithoutLogin(t *testing.T) {
+	app := newTestApplication(t)
+	app.oidcProviders = []*oidc.Provider{oidc.NewProvider(oidc.Config{Name: "test", Issuer: "https://idp.test", ClientID: "snippetbox"}, nil)}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// A callback which wasn't started by this browser is refused.
+	code, _, _ := ts.get(t, "/user/login/oidc/test/callback?code=abc&state=xyz")
+	if code != http.StatusBadRequest {
+		t.Errorf("want %d; got %d", http.StatusBadRequest, code)
+	}
+
+	code, _, _ = ts.get(t, "/user/login/oidc/unknown")
+	if code != http.StatusNotFound {
+		t.Errorf("want %d; got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		wantCode   int
+		wantBody   []byte
+		wantNoBody []byte
+	}{
+		{"Anonymous", "", http.StatusSeeOther, nil, nil},
+		{"User", "alice@example.com", http.StatusForbidden, nil, nil},
+		{"Moderator", "erin@example.com", http.StatusOK, []byte("/admin/snippet/delete"), []byte("/admin/user/active")},
+		{"Admin", "dave@example.com", http.StatusOK, []byte("/admin/user/active"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.email != "" {
+				ts.logInAs(t, tt.email)
+			}
+
+			code, _, body := ts.get(t, "/admin")
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+			if tt.wantNoBody != nil && bytes.Contains(body, tt.wantNoBody) {
+				t.Errorf("want body %s not to contain %q", body, tt.wantNoBody)
+			}
+		})
+	}
+}
+
+func TestAdminActions(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+	}{
+		{"Moderator deletes snippet", "erin@example.com", "/admin/snippet/delete", url.Values{"id": {"1"}}, http.StatusSeeOther, "/admin"},
+		{"Moderator deletes missing snippet", "erin@example.com", "/admin/snippet/delete", url.Values{"id": {"2"}}, http.StatusNotFound, ""},
+		{"Moderator deletes invalid ID", "erin@example.com", "/admin/snippet/delete", url.Values{"id": {"foo"}}, http.StatusBadRequest, ""},
+		{"User deletes snippet", "alice@example.com", "/admin/snippet/delete", url.Values{"id": {"1"}}, http.StatusForbidden, ""},
+		{"Moderator deactivates user", "erin@example.com", "/admin/user/active", url.Values{"id": {"1"}, "active": {"false"}}, http.StatusForbidden, ""},
+		{"Admin deactivates user", "dave@example.com", "/admin/user/active", url.Values{"id": {"1"}, "active": {"false"}}, http.StatusSeeOther, "/admin"},
+		{"Admin deactivates missing user", "dave@example.com", "/admin/user/active", url.Values{"id": {"99"}, "active": {"false"}}, http.StatusNotFound, ""},
+		{"Admin changes role", "dave@example.com", "/admin/user/role", url.Values{"id": {"1"}, "role": {"moderator"}}, http.StatusSeeOther, "/admin"},
+		{"Admin sets unknown role", "dave@example.com", "/admin/user/role", url.Values{"id": {"1"}, "role": {"root"}}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.logInAs(t, tt.email)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, tt.form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if header.Get("Location") != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, header.Get("Location"))
+			}
+		})
+	}
+}
+
+func TestAdminCannotDeactivateSelf(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.logInAs(t, "dave@example.com")
+
+	ts.postForm(t, "/admin/user/active", url.Values{"id": {"3"}, "active": {"false"}})
+	_, _, body := ts.get(t, "/admin")
+	if !bytes.Contains(body, []byte("You can&#39;t change your own account here.")) {
+		t.Errorf("want body %s to contain the warning", body)
+	}
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.logIn(t)
+
+	_, _, body := ts.get(t, "/account")
+	for _, want := range [][]byte{[]byte("(this device)"), []byte("Other Device"), []byte("Log out all other sessions")} {
+		if !bytes.Contains(body, want) {
+			t.Errorf("want body %s to contain %q", body, want)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+	}{
+		{"Revoke other session", "/account/sessions/revoke", url.Values{"id": {"99"}}, http.StatusSeeOther, "/account"},
+		{"Revoke unknown session", "/account/sessions/revoke", url.Values{"id": {"5"}}, http.StatusNotFound, ""},
+		{"Revoke invalid ID", "/account/sessions/revoke", url.Values{"id": {"foo"}}, http.StatusBadRequest, ""},
+		{"Revoke all other sessions", "/account/sessions/revoke-others", url.Values{}, http.StatusSeeOther, "/account"},
+		{"Revoke current session", "/account/sessions/revoke", url.Values{"id": {"1"}}, http.StatusSeeOther, "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.postForm(t, tt.urlPath, tt.form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if header.Get("Location") != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, header.Get("Location"))
+			}
+		})
+	}
+
+	// After revoking the current session the user is logged out.
+	code, header, _ := ts.get(t, "/account")
+	if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+		t.Errorf("want redirect to %q; got %d %q", "/user/login", code, header.Get("Location"))
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	tests := []struct {
+		name     string
+		remember string
+		wantSet  string
+	}{
+		{"Remember", "true", "remember-1:valid"},
+		{"Don't remember", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			form := url.Values{}
+			form.Add("email", "alice@example.com")
+			form.Add("password", "validPa$$word")
+			form.Add("remember", tt.remember)
+			rs, err := ts.Client().PostForm(ts.URL+"/user/login", form)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			var got string
+			for _, c := range rs.Cookies() {
+				if c.Name == rememberCookieName {
+					got = c.Value
+				}
+			}
+			if got != tt.wantSet {
+				t.Errorf("want remember cookie %q; got %q", tt.wantSet, got)
+			}
+		})
+	}
+}
+
+func TestRestoreSession(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		wantCode     int
+		wantCookie   *string
+		wantLoginMsg []byte
+	}{
+		{"Valid token", "remember-1:valid", http.StatusOK, strPtr("remember-1:rotated"), nil},
+		{"Unknown token", "remember-1:unknown", http.StatusSeeOther, strPtr(""), nil},
+		{"Reused token", "remember-1:stolen", http.StatusSeeOther, strPtr(""), []byte("logged out everywhere")},
+		// A concurrent request has just rotated the token: the new cookie is left alone.
+		{"Just rotated token", "remember-1:previous", http.StatusSeeOther, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// The session cookie has expired, only the remember cookie is left.
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts.Client().Jar.SetCookies(u, []*http.Cookie{{Name: rememberCookieName, Value: tt.token, Path: "/"}})
+
+			rs, err := ts.Client().Get(ts.URL + "/account")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+
+			// The remember token is rotated on use, and the invalid ones are deleted.
+			var got *http.Cookie
+			for _, c := range rs.Cookies() {
+				if c.Name == rememberCookieName {
+					got = c
+				}
+			}
+			switch {
+			case tt.wantCookie == nil && got != nil:
+				t.Errorf("want the remember cookie untouched; got %v", got)
+			case tt.wantCookie != nil && (got == nil || got.Value != *tt.wantCookie):
+				t.Errorf("want remember cookie %q; got %v", *tt.wantCookie, got)
+			}
+
+			if tt.wantLoginMsg != nil {
+				_, _, body := ts.get(t, "/user/login")
+				if !bytes.Contains(body, tt.wantLoginMsg) {
+					t.Errorf("want body %s to contain %q", body, tt.wantLoginMsg)
+				}
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestShowPrivateSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+	}{
+		{"Author", "alice@example.com", http.StatusOK},
+		{"Another user", "erin@example.com", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.logInAs(t, tt.email)
+
+			code, header, body := ts.get(t, "/snippet/3")
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if code == http.StatusOK {
+				if !bytes.Contains(body, []byte("Dear diary...")) {
+					t.Errorf("want body %s to contain %q", body, "Dear diary...")
+				}
+				if header.Get("Cache-Control") != "no-store" {
+					t.Errorf("want Cache-Control %q; got %q", "no-store", header.Get("Cache-Control"))
+				}
+			}
+		})
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantCode   int
+		wantBody   []byte
+		wantNoBody []byte
+	}{
+		{"Valid ID", "/user/1", http.StatusOK, []byte("An old silent pond"), []byte("A private diary")},
+		{"No snippets", "/user/2", http.StatusOK, []byte("nothing to see here"), nil},
+		{"Inactive user", "/user/5", http.StatusNotFound, nil, nil},
+		{"Non-existent ID", "/user/99", http.StatusNotFound, nil, nil},
+		{"String ID", "/user/foo", http.StatusNotFound, nil, nil},
+		{"Page past the end", "/user/1?page=2", http.StatusNotFound, nil, nil},
+		{"Invalid page", "/user/1?page=0", http.StatusNotFound, nil, nil},
+		{"Anonymous shortcut", "/user/me", http.StatusSeeOther, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+			if tt.wantNoBody != nil && bytes.Contains(body, tt.wantNoBody) {
+				t.Errorf("want body %s not to contain %q", body, tt.wantNoBody)
+			}
+		})
+	}
+
+	// The shortcut leads the current user to their own profile.
+	ts.logIn(t)
+	code, header, _ := ts.get(t, "/user/me")
+	if code != http.StatusSeeOther || header.Get("Location") != "/user/1" {
+		t.Errorf("want redirect to %q; got %d %q", "/user/1", code, header.Get("Location"))
+	}
+}
+
+func TestShowTeamSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+	}{
+		{"Team owner", "alice@example.com", http.StatusOK},
+		{"Team member", "erin@example.com", http.StatusOK},
+		{"Outsider", "dave@example.com", http.StatusNotFound},
+		{"Anonymous", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.email != "" {
+				ts.logInAs(t, tt.email)
+			}
+
+			code, header, body := ts.get(t, "/snippet/5")
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if code == http.StatusOK {
+				if !bytes.Contains(body, []byte("For the team only...")) {
+					t.Errorf("want body %s to contain %q", body, "For the team only...")
+				}
+				if header.Get("Cache-Control") != "no-store" {
+					t.Errorf("want Cache-Control %q; got %q", "no-store", header.Get("Cache-Control"))
+				}
+			}
+		})
+	}
+}
+
+func TestShowTeam(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		urlPath    string
+		wantCode   int
+		wantBody   []byte
+		wantInvite bool
+	}{
+		{"Owner", "alice@example.com", "/team/1", http.StatusOK, []byte("Team notes"), true},
+		{"Member", "erin@example.com", "/team/1", http.StatusOK, []byte("Team notes"), false},
+		{"Outsider", "dave@example.com", "/team/1", http.StatusNotFound, nil, false},
+		{"Missing team", "alice@example.com", "/team/2", http.StatusNotFound, nil, false},
+		{"Teams list", "alice@example.com", "/teams", http.StatusOK, []byte("Core"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.logInAs(t, tt.email)
+
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+			if got := bytes.Contains(body, []byte("Send invitation")); got != tt.wantInvite {
+				t.Errorf("want invitation form %v; got %v", tt.wantInvite, got)
+			}
+		})
+	}
+}
+
+func TestTeamActions(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+	}{
+		{"Create team", "alice@example.com", "/teams", url.Values{"name": {"Docs"}}, http.StatusSeeOther, "/team/2"},
+		{"Create team without name", "alice@example.com", "/teams", url.Values{"name": {""}}, http.StatusOK, ""},
+		{"Owner invites", "alice@example.com", "/team/1/invite", url.Values{"email": {"dave@example.com"}}, http.StatusSeeOther, "/team/1"},
+		{"Owner invites invalid email", "alice@example.com", "/team/1/invite", url.Values{"email": {"dave"}}, http.StatusOK, ""},
+		{"Member invites", "erin@example.com", "/team/1/invite", url.Values{"email": {"dave@example.com"}}, http.StatusForbidden, ""},
+		{"Outsider invites", "dave@example.com", "/team/1/invite", url.Values{"email": {"dave@example.com"}}, http.StatusNotFound, ""},
+		{"Owner removes member", "alice@example.com", "/team/1/remove", url.Values{"user": {"4"}}, http.StatusSeeOther, "/team/1"},
+		{"Owner removes non-member", "alice@example.com", "/team/1/remove", url.Values{"user": {"3"}}, http.StatusNotFound, ""},
+		{"Member removes owner", "erin@example.com", "/team/1/remove", url.Values{"user": {"1"}}, http.StatusForbidden, ""},
+		{"Member leaves", "erin@example.com", "/team/1/leave", nil, http.StatusSeeOther, "/teams"},
+		{"Last owner leaves", "alice@example.com", "/team/1/leave", nil, http.StatusSeeOther, "/team/1"},
+		{"Outsider leaves", "dave@example.com", "/team/1/leave", nil, http.StatusNotFound, ""},
+		{"Invitee accepts", "dave@example.com", "/team/invitation", url.Values{"token": {"invite-dave"}}, http.StatusSeeOther, "/team/1"},
+		{"Somebody else accepts", "erin@example.com", "/team/invitation", url.Values{"token": {"invite-dave"}}, http.StatusSeeOther, "/teams"},
+		{"Member posts to the team", "erin@example.com", "/snippet/create", url.Values{"title": {"T"}, "content": {"C"}, "expires": {"7"}, "visibility": {"team"}, "team": {"1"}}, http.StatusSeeOther, "/snippet/2"},
+		{"Outsider posts to the team", "dave@example.com", "/snippet/create", url.Values{"title": {"T"}, "content": {"C"}, "expires": {"7"}, "visibility": {"team"}, "team": {"2"}}, http.StatusOK, ""},
+		{"Team snippet without team", "erin@example.com", "/snippet/create", url.Values{"title": {"T"}, "content": {"C"}, "expires": {"7"}, "visibility": {"team"}}, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.logInAs(t, tt.email)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, tt.form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if header.Get("Location") != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, header.Get("Location"))
+			}
+		})
+	}
+}
+
+func TestTeamInvitation(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		token    string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Invitee", "dave@example.com", "invite-dave", http.StatusOK, []byte("Join the team")},
+		{"Somebody else", "erin@example.com", "invite-dave", http.StatusOK, []byte("has been sent to dave@example.com")},
+		{"Invalid token", "dave@example.com", "foo", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.logInAs(t, tt.email)
+
+			code, _, body := ts.get(t, "/team/invitation?token="+tt.token)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// A failed login, a successful one and an admin action are recorded.
+	form := url.Values{}
+	form.Add("email", "dave@example.com")
+	form.Add("password", "wrongPa$$word")
+	ts.postForm(t, "/user/login", form)
+	ts.logInAs(t, "dave@example.com")
+	ts.postForm(t, "/admin/user/role", url.Values{"id": {"1"}, "role": {"moderator"}})
+
+	want := []string{models.AuditLoginFailed, models.AuditLogin, models.AuditUserRoleChange}
+	got := app.auditEvents.(*mock.AuditModel).Actions()
+	if len(got) != len(want) {
+		t.Fatalf("want %v; got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want %v; got %v", want, got)
+		}
+	}
+
+	code, _, body := ts.get(t, "/admin/audit")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte(models.AuditUserRoleChange)) {
+		t.Errorf("want body %s to contain %q", body, models.AuditUserRoleChange)
+	}
+
+	// The export is a JSON object per line, the oldest first, and it is recorded as well.
+	code, header, body := ts.get(t, "/admin/audit/export")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("want Content-Type %q; got %q", "application/x-ndjson", header.Get("Content-Type"))
+	}
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	if len(lines) != 4 {
+		t.Fatalf("want %d lines; got %d", 4, len(lines))
+	}
+	var e models.AuditEvent
+	err := json.Unmarshal(lines[0], &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Action != models.AuditLoginFailed || !bytes.Contains(e.Details, []byte("dave@example.com")) {
+		t.Errorf("want the failed login of dave@example.com; got %s %s", e.Action, e.Details)
+	}
+}
+
+func TestAuditLogRequiresAdmin(t *testing.T) {
+	for _, urlPath := range []string{"/admin/audit", "/admin/audit/export"} {
+		t.Run(urlPath, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.logInAs(t, "erin@example.com")
+
+			code, _, _ := ts.get(t, urlPath)
+			if code != http.StatusForbidden {
+				t.Errorf("want %d; got %d", http.StatusForbidden, code)
+			}
+		})
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Every response has its own nonce, and the script tags of the page carry it.
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		_, header, body := ts.get(t, "/")
+		match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(header.Get("Content-Security-Policy"))
+		if match == nil {
+			t.Fatalf("want a nonce in %q", header.Get("Content-Security-Policy"))
+		}
+		if !bytes.Contains(body, []byte(`nonce="`+match[1]+`"`)) {
+			t.Errorf("want body to contain the nonce %q", match[1])
+		}
+		if header.Get("Strict-Transport-Security") == "" {
+			t.Errorf("want Strict-Transport-Security over HTTPS")
+		}
+		nonces = append(nonces, match[1])
+	}
+	if nonces[0] == nonces[1] {
+		t.Errorf("want different nonces; got %q twice", nonces[0])
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{"report-uri", "application/csp-report", `{"csp-report": {"document-uri": "https://snippetbox.test/", "blocked-uri": "inline", "violated-directive": "script-src"}}`, http.StatusNoContent},
+		{"Reporting API", "application/reports+json", `[{"type": "csp-violation", "body": {"documentURL": "https://snippetbox.test/", "blockedURL": "inline", "effectiveDirective": "script-src-elem"}}]`, http.StatusNoContent},
+		{"Invalid JSON", "application/csp-report", `{`, http.StatusBadRequest},
+		{"Too large", "application/csp-report", `{"csp-report": {"blocked-uri": "` + strings.Repeat("a", cspReportMaxBytes) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Post(ts.URL+"/csp-report", tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+		})
+	}
+}
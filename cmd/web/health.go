@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How long the readiness check waits for the database.
+	readyDBTimeout = 2 * time.Second
+	// With more background tasks running at once than this (for example, emails
+	// stuck on a slow SMTP server) the instance is reported as not ready.
+	maxBackgroundTasks = 100
+)
+
+// The result of one check of /readyz. The reason of a failure is only logged:
+// the endpoint is public, and the errors (of the database driver, for example)
+// may reveal the internals of the deployment.
+type healthCheck struct {
+	Status string `json:"status"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// The healthz handler is the liveness probe: it only shows that the process
+// is able to serve requests, so it never depends on the database.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeHealth(w, healthReport{Status: "ok"})
+}
+
+// The readyz handler is the readiness probe: it checks that the instance can
+// serve the users, and responds with 503 if any of the checks has failed, so
+// that no traffic is sent here until the problem is gone.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{Status: "ok", Checks: map[string]healthCheck{}}
+	check := func(name string, err error) {
+		if err != nil {
+			report.Status = "fail"
+			report.Checks[name] = healthCheck{Status: "fail"}
+			app.requestLogger(r).Warn("readiness check failed", "check", name, "error", err)
+			return
+		}
+		report.Checks[name] = healthCheck{Status: "ok"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyDBTimeout)
+	defer cancel()
+	check("database", app.db.PingContext(ctx))
+
+	var err error
+	if len(app.templateCache) == 0 {
+		err = fmt.Errorf("the template cache is empty")
+	}
+	check("templates", err)
+
+	err = nil
+	if n := atomic.LoadInt64(&app.backgroundTasks); n > maxBackgroundTasks {
+		err = fmt.Errorf("%d background tasks are running", n)
+	}
+	check("background", err)
+
+	err = nil
+	if atomic.LoadInt32(&app.draining) == 1 {
+		err = fmt.Errorf("shutting down")
+	}
+	check("shutdown", err)
+
+	app.writeHealth(w, report)
+}
+
+func (app *application) writeHealth(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+	app.db = &testDB{err: errors.New("connection refused")}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The liveness probe doesn't depend on the database
+	code, header, body := ts.get(t, "/healthz")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("want content type %q; got %q", "application/json", ct)
+	}
+	if string(body) != `{"status":"ok"}`+"\n" {
+		t.Errorf("want body %q; got %q", `{"status":"ok"}`, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(app *application)
+		wantCode   int
+		wantFailed string
+	}{
+		{"Ready", func(app *application) {}, http.StatusOK, ""},
+		{"Database is down", func(app *application) {
+			app.db = &testDB{err: errors.New("connection refused")}
+		}, http.StatusServiceUnavailable, "database"},
+		{"No templates", func(app *application) {
+			app.templateCache = nil
+		}, http.StatusServiceUnavailable, "templates"},
+		{"Too many background tasks", func(app *application) {
+			app.backgroundTasks = maxBackgroundTasks + 1
+		}, http.StatusServiceUnavailable, "background"},
+		{"Draining", func(app *application) {
+			atomic.StoreInt32(&app.draining, 1)
+		}, http.StatusServiceUnavailable, "shutdown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			tt.setup(app)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+
+			var report healthReport
+			if err := json.Unmarshal(body, &report); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"database", "templates", "background", "shutdown"} {
+				want := "ok"
+				if name == tt.wantFailed {
+					want = "fail"
+				}
+				if got := report.Checks[name].Status; got != want {
+					t.Errorf("check %s: want %q; got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyzHidesErrors(t *testing.T) {
+	app := newTestApplication(t)
+	app.db = &testDB{err: errors.New("dial tcp 10.0.0.5:3306: connection refused")}
+	var buf bytes.Buffer
+	testLogger, err := logger.New(&buf, logger.FormatText, logger.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.logger = testLogger
+
+	rr := httptest.NewRecorder()
+	app.readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want %d; got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if body := rr.Body.String(); strings.Contains(body, "10.0.0.5") {
+		t.Errorf("want the error hidden from the response; got %q", body)
+	}
+	if log := buf.String(); !strings.Contains(log, "10.0.0.5") || !strings.Contains(log, "check=database") {
+		t.Errorf("want the error of the database check logged; got %q", log)
+	}
+}
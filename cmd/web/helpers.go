@@ -2,21 +2,27 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/oidc"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// The serverError helper writes an error message and stack trace to the errorLog,
+// The serverError helper writes an error message and stack trace to the log (with
+// the ID of the request, so that a user's report can be matched with the entry),
 // then sends a generic 500 Internal Server Error response to the user.
-func (app *application) serverError(w http.ResponseWriter, err error) {
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	// Получаем полный stack trace ошибки в текущей горутине
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-
-	// Output с calldepth=2 вместо обычного Print позволяет вывести stack trace ошибки без учета верхушки стека
-	// Верхушка стека бесполезна = названию данного файла и данной строки (так как отсюда производится запись в лог)
-	app.errorLog.Output(2, trace)
+	app.requestLogger(r).Error("server error", "error", err, "trace", string(debug.Stack()))
 
 	// Отправляем ответ на запрос со статусом = 500 (Internal Server Error)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -61,18 +67,90 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 	// Add the authentication status to the template data
 	// It's useful for rendering of almost each page of the site
 	td.IsAuthenticated = app.isAuthenticated(r)
+	td.AuthenticatedUser = app.authenticatedUser(r)
+
+	// The login page shows a button for every configured identity provider.
+	td.OIDCProviders = app.oidcProviders
+
+	// The nonce of the Content Security Policy, for the <script> tags.
+	td.CSPNonce, _ = r.Context().Value(contextKeyCSPNonce).(string)
 
 	return td
 }
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, name string, td *templateData) {
+	buf, err := app.renderPage(r, name, td)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Если рендеринг HTML страницы прошел успешно, пишем содержимое буфера в http.ResponseWriter клиенту
+	buf.WriteTo(w)
+}
+
+// The renderWithETag helper renders the page like render, but also sets the ETag
+// header from the content of the page, and responds with 304 Not Modified if the
+// browser already has exactly the same page.
+func (app *application) renderWithETag(w http.ResponseWriter, r *http.Request, name string, td *templateData) {
+	buf, err := app.renderPage(r, name, td)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The nonce is different on every request, so it's left out of the entity tag.
+	page := buf.Bytes()
+	if nonce, _ := r.Context().Value(contextKeyCSPNonce).(string); nonce != "" {
+		page = bytes.ReplaceAll(page, []byte(nonce), nil)
+	}
+	sum := sha256.Sum256(page)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		// The headers of a 304 response replace the stored ones, and the nonce in
+		// a new Content Security Policy wouldn't match the one in the cached page.
+		w.Header().Del("Content-Security-Policy")
+		w.Header().Del("Content-Security-Policy-Report-Only")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	buf.WriteTo(w)
+}
+
+// The etagMatches function reports whether the If-None-Match header matches the
+// entity tag. The comparison is weak, as RFC 7232 requires for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// The renderPage helper renders the page into a buffer.
+func (app *application) renderPage(r *http.Request, name string, td *templateData) (*bytes.Buffer, error) {
 	// По имени файла-шаблона страницы (например, 'home.page.tmpl') достаем из кэша шаблонов
 	//   весь набор необходимых для ее рендеринга файлов с шаблонами (template set)
 	// Если не нашли в кэше соответствующий набор шаблонов, будем отвечать ошибкой сервера
-	ts, ok := app.templateCache[name]
+	// При разработке (-dev) шаблоны заново читаются с диска для каждой страницы,
+	// чтобы изменения в них были видны сразу
+	cache := app.templateCache
+	if app.dev {
+		var err error
+		cache, err = newTemplateCache(app.htmlFiles, app.assets)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ts, ok := cache[name]
 	if !ok {
-		app.serverError(w, fmt.Errorf("The template %s does not exist", name))
-		return
+		return nil, fmt.Errorf("The template %s does not exist", name)
 	}
 
 	// Инициализируем буфер, в который отрендерим HTML страницу перед отправкой клиенту
@@ -82,14 +160,14 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, name stri
 	buf := new(bytes.Buffer)
 
 	// Пытаемся отрендерить HTML страницу с динамическим контентом, результат пишем в буфер
+	span := app.startSpan(r.Context(), "render")
+	span.Set("template", name)
 	err := ts.Execute(buf, app.addDefaultData(td, r))
+	endSpan(span, err)
 	if err != nil {
-		app.serverError(w, err)
-		return
+		return nil, err
 	}
-
-	// Если рендеринг HTML страницы прошел успешно, пишем содержимое буфера в http.ResponseWriter клиенту
-	buf.WriteTo(w)
+	return buf, nil
 }
 
 // Return true if the current request is from authenticated user, otherwise return false.
@@ -99,4 +177,296 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 		return false
 	}
 	return isAuthenticated
-}
\ No newline at end of file
+}
+
+// Return the record of the authenticated user, or nil if the current request isn't authenticated.
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+	user, ok := r.Context().Value(contextKeyAuthenticatedUser).(*models.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// The clientCertificateUser method returns the user identified by the client certificate
+// of the request, or nil if there is no verified certificate (see -tls-client-auth) or
+// no active user with its email address.
+func (app *application) clientCertificateUser(r *http.Request) (*models.User, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	email := certificateEmail(r.TLS.VerifiedChains[0][0])
+	if email == "" {
+		return nil, nil
+	}
+	user, err := app.userModel(r).GetByEmail(email)
+	if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Return the server-side session of the authenticated user, or nil if the current request isn't authenticated.
+func (app *application) loginSession(r *http.Request) *models.Session {
+	s, ok := r.Context().Value(contextKeyLoginSession).(*models.Session)
+	if !ok {
+		return nil
+	}
+	return s
+}
+
+// The logIn helper starts a new server-side session for the user and puts its token
+// into the session cookie, so that they are now 'logged in'. The session is
+// stored in the database, so that it can be revoked from another device.
+//
+// If remember is true, the user has ticked "remember me": the session lives for
+// rememberTTL, and a separate long-lived cookie with a remember token lets the
+// authenticate middleware restore it after the session cookie has expired.
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) error {
+	token, err := app.loginSessions.New(user.ID, clientIP(r), r.UserAgent(), app.session.Lifetime)
+	if err != nil {
+		return err
+	}
+	app.session.Put(r, "sessionToken", token)
+
+	if remember {
+		rememberToken, err := app.loginSessions.Remember(token, rememberTTL)
+		if err != nil {
+			return err
+		}
+		app.setRememberCookie(w, rememberToken)
+	}
+	return nil
+}
+
+// The restoreSession helper logs in the user again with the remember token from
+// the cookie, if there is one. It returns the token of the restored session, or
+// an empty string if the session can't be restored.
+func (app *application) restoreSession(w http.ResponseWriter, r *http.Request) (string, error) {
+	cookie, err := r.Cookie(rememberCookieName)
+	if err != nil {
+		return "", nil
+	}
+
+	token, rememberToken, err := app.loginSessions.Restore(cookie.Value, rememberTTL)
+	if errors.Is(err, models.ErrTokenRotated) {
+		// A concurrent request of the same browser has just rotated the token. The
+		// browser already has the new one, which mustn't be overwritten or deleted.
+		return "", nil
+	} else if errors.Is(err, models.ErrNoRecord) {
+		app.clearRememberCookie(w)
+		return "", nil
+	} else if errors.Is(err, models.ErrTokenReuse) {
+		// The token has been used by somebody else after it was rotated. All the
+		// sessions of the user have been deleted, so the user has to log in again.
+		app.requestLogger(r).Warn("remember token reused, all sessions of the user have been revoked", "ip", clientIP(r), "user_agent", r.UserAgent())
+		app.clearRememberCookie(w)
+		app.session.Put(r, "flash", "For your security you've been logged out everywhere. Please log in again and change your password.")
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	app.session.Put(r, "sessionToken", token)
+	app.setRememberCookie(w, rememberToken)
+	return token, nil
+}
+
+// The remember token is kept in its own cookie, because it must outlive the session cookie.
+func (app *application) setRememberCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(rememberTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.session.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (app *application) clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   app.session.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// The background helper runs the given function in a separate goroutine (for example,
+// to send an email without making the user wait for the SMTP server). Any panic inside
+// the function is recovered and logged, so that it can't bring down the whole server.
+// The shutdown waits for the running functions to finish.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	atomic.AddInt64(&app.backgroundTasks, 1)
+	go func() {
+		defer app.wg.Done()
+		defer atomic.AddInt64(&app.backgroundTasks, -1)
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error("background task panicked", "error", fmt.Sprint(err), "trace", string(debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// The isHTTPS function reports whether the client made the request over HTTPS,
+// either to us or to the trusted proxy in front of us.
+func isHTTPS(r *http.Request) bool {
+	if proto, ok := r.Context().Value(contextKeyForwardedProto).(string); ok {
+		return proto == "https"
+	}
+	return r.TLS != nil
+}
+
+// The isTrustedProxy method reports whether the address belongs to one of the -trusted-proxies.
+func (app *application) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range app.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the IP address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// The loginThrottled helper checks whether the client has to wait before it may try
+// to log in again, either because of the failures from its IP address or because of
+// the failures for the account (identified by the key, for example "email:alice@example.com").
+func (app *application) loginThrottled(r *http.Request, key string) (bool, error) {
+	wait, err := app.ipGuard.Wait("ip:" + clientIP(r))
+	if err != nil || wait > 0 {
+		return wait > 0, err
+	}
+	wait, err = app.accountGuard.Wait(key)
+	return wait > 0, err
+}
+
+// The loginFailed helper records a failed login attempt for the IP address of the
+// client and for the account. When either of them gets locked out, we write it to the
+// error log, so that somebody can look into it.
+func (app *application) loginFailed(r *http.Request, key string) error {
+	app.metrics.loginFailures.Inc()
+	ip := "ip:" + clientIP(r)
+	delay, locked, err := app.ipGuard.Fail(ip)
+	if err != nil {
+		return err
+	}
+	if locked {
+		app.requestLogger(r).Warn("login lockout", "key", ip, "delay", delay)
+	}
+
+	delay, locked, err = app.accountGuard.Fail(key)
+	if err != nil {
+		return err
+	}
+	if locked {
+		app.requestLogger(r).Warn("login lockout", "key", key, "delay", delay, "ip", clientIP(r))
+	}
+	return nil
+}
+
+// The audit helper appends an event to the audit log. The actor is the user who
+// did it (0 if nobody is logged in), details are stored as a JSON object. The
+// request doesn't fail if the event can't be recorded, but the error is logged.
+func (app *application) audit(r *http.Request, actorID int, action string, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	js, err := json.Marshal(details)
+	if err != nil {
+		app.requestLogger(r).Error("audit", "action", action, "error", err)
+		return
+	}
+
+	err = app.auditEvents.Insert(&models.AuditEvent{
+		ActorID:   actorID,
+		Action:    action,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Details:   js,
+	})
+	if err != nil {
+		app.requestLogger(r).Error("audit", "action", action, "actor_id", actorID, "error", err)
+	}
+}
+
+// The completeLogin helper finishes the login once the identity of the user has been
+// checked (with a password or with an identity provider). If the user has turned on
+// two-factor authentication, they are sent to the second step of the login instead.
+// If remember is true, the login outlives the session cookie (see logIn). The method
+// (for example, "password") is written to the audit log.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, method string, remember bool) {
+	if user.TwoFactorEnabled {
+		app.session.Put(r, "twoFactorUserID", user.ID)
+		app.session.Put(r, "twoFactorStarted", int(time.Now().Unix()))
+		app.session.Put(r, "twoFactorRemember", remember)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Start a session for the user, so that they are now 'logged in'.
+	err := app.logIn(w, r, user, remember)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, models.AuditLogin, map[string]interface{}{"method": method})
+
+	// Redirect the user to the create snippet page.
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// Return the OpenID Connect provider with the given name, or nil if there is no such provider.
+func (app *application) oidcProvider(name string) *oidc.Provider {
+	for _, p := range app.oidcProviders {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Return the URL which the identity provider redirects the user back to.
+func (app *application) oidcRedirectURI(p *oidc.Provider) string {
+	return app.baseURL + "/user/login/oidc/" + p.Name + "/callback"
+}
+
+// The canView helper reports whether the current user may see the snippet. Public
+// and unlisted snippets are visible to everybody, private ones only to their author,
+// and team ones only to the members of the team.
+func (app *application) canView(r *http.Request, s *models.Snippet) (bool, error) {
+	switch s.Visibility {
+	case models.VisibilityPrivate:
+		user := app.authenticatedUser(r)
+		return user != nil && s.UserID != 0 && user.ID == s.UserID, nil
+	case models.VisibilityTeam:
+		user := app.authenticatedUser(r)
+		if user == nil || s.TeamID == 0 {
+			return false, nil
+		}
+		return app.teams.IsMember(s.TeamID, user.ID)
+	default:
+		return true, nil
+	}
+}
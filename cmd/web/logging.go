@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"github.com/Dimau/snippetbox/pkg/tracing"
+	"net/http"
+	"regexp"
+)
+
+// The request IDs which are accepted from the trusted proxies. Anything else
+// (for example, a very long header) is replaced with a new ID.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// The newRequestID function returns a random ID for a request.
+func newRequestID() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The requestLogger method returns the logger which adds the ID of the request
+// to the entries. Outside of a request (r is nil) it returns the main logger.
+func (app *application) requestLogger(r *http.Request) *logger.Logger {
+	if r == nil {
+		return app.logger
+	}
+	id, ok := r.Context().Value(contextKeyRequestID).(string)
+	if !ok {
+		return app.logger
+	}
+	if span := tracing.FromContext(r.Context()); span != nil {
+		return app.logger.With("request_id", id, "trace_id", span.Context().TraceID.String())
+	}
+	return app.logger.With("request_id", id)
+}
+
+// The responseRecorder type wraps an http.ResponseWriter and remembers the status
+// code and the size of the response, so that they can be logged.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// The Flush method lets the handlers stream the response (for example, the export
+// of the audit log) through the wrapper.
+func (rw *responseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// The Unwrap method is used by http.ResponseController.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// The statusCode method returns the status of the response. A handler which
+// hasn't written anything has responded with 200.
+func (rw *responseRecorder) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
@@ -1,122 +1,397 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/Dimau/snippetbox/pkg/lockout"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"github.com/Dimau/snippetbox/pkg/mailer"
 	"github.com/Dimau/snippetbox/pkg/models"
 	"github.com/Dimau/snippetbox/pkg/models/mysql"
+	"github.com/Dimau/snippetbox/pkg/oidc"
+	"github.com/Dimau/snippetbox/pkg/ratelimit"
+	"github.com/Dimau/snippetbox/pkg/tracing"
+	"github.com/Dimau/snippetbox/ui"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golangcollege/sessions"
 	"html/template"
-	"log"
+	"io/fs"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 type contextKey string
 
-const contextKeyIsAuthenticated = contextKey("isAuthenticated")
+const (
+	contextKeyIsAuthenticated   = contextKey("isAuthenticated")
+	contextKeyAuthenticatedUser = contextKey("authenticatedUser")
+	contextKeyLoginSession      = contextKey("loginSession")
+	contextKeyCSPNonce          = contextKey("cspNonce")
+	contextKeyForwardedProto    = contextKey("forwardedProto")
+	contextKeyRequestID         = contextKey("requestID")
+	contextKeyRoute             = contextKey("route")
+)
 
 type application struct {
-	errorLog      *log.Logger
-	infoLog       *log.Logger
+	accountGuard *lockout.Guard
+	assets       *assetManifest
+	auditEvents  interface {
+		Insert(*models.AuditEvent) error
+		Recent(int) ([]*models.AuditEvent, error)
+		Each(func(*models.AuditEvent) error) error
+	}
+	backgroundTasks int64 // running background functions, accessed atomically
+	baseURL         string
+	csp             string
+	cspReportOnly   bool
+	db              interface {
+		PingContext(context.Context) error
+	}
+	dev        bool
+	draining   int32 // 1 when the server is shutting down, accessed atomically
+	hstsMaxAge time.Duration
+	htmlFiles  fs.FS
+	identities interface {
+		Get(string, string) (int, error)
+		Insert(string, string, int) error
+	}
+	ipGuard *lockout.Guard
+	logger  *logger.Logger
+	mailer  interface {
+		Send(string, string, string) error
+	}
+	loginSessions interface {
+		New(int, string, string, time.Duration) (string, error)
+		Get(string) (*models.Session, error)
+		Touch(int, string) error
+		List(int) ([]*models.Session, error)
+		Revoke(int, int) error
+		RevokeOthers(int, int) error
+		Remember(string, time.Duration) (string, error)
+		Restore(string, time.Duration) (string, string, error)
+	}
+	metrics       *appMetrics
+	metricsAddr   string
+	oidcProviders []*oidc.Provider
+	rateLimiters  map[string]*ratelimit.Limiter
 	session       *sessions.Session
 	templateCache map[string]*template.Template
-	snippets      interface {
-		Insert(string, string, string) (int, error)
-		Get(int) (*models.Snippet, error)
-		Latest() ([]*models.Snippet, error)
+	snippets      snippetStore
+	teams         interface {
+		Create(int, string) (int, error)
+		Get(int, int) (*models.Team, error)
+		ForUser(int) ([]*models.Team, error)
+		IsMember(int, int) (bool, error)
+		Members(int, int) ([]*models.TeamMember, error)
+		Invite(int, int, string, time.Duration) (string, error)
+		Invitation(string) (*models.TeamInvitation, error)
+		Accept(string, int, string) (int, error)
+		Leave(int, int) error
+		RemoveMember(int, int, int) error
 	}
-	users         interface {
-		Insert(string, string, string) error
-		Authenticate(string, string) (int, error)
-		Get(int) (*models.User, error)
+	tracer         *tracing.Tracer
+	trustedProxies []*net.IPNet
+	tokens         interface {
+		New(int, time.Duration, string, string) (string, error)
+		Consume(string, string) (*models.Token, error)
 	}
+	users userStore
+	wg    sync.WaitGroup // background functions
+}
+
+// The snippetStore and userStore interfaces are the methods of the snippet and user
+// models the handlers use. They are named, unlike the other models, because the
+// tracing wrappers in tracing.go implement them too.
+type snippetStore interface {
+	Insert(int, int, string, string, string, string) (int, error)
+	Get(int) (*models.Snippet, error)
+	Latest() ([]*models.Snippet, error)
+	Recent(int) ([]*models.Snippet, error)
+	PublicByUser(int, int, int) ([]*models.Snippet, error)
+	ByTeam(int, int, int, int) ([]*models.Snippet, error)
+	Count() (int, int, error)
+	Delete(int) error
 }
 
-//type application struct {
-//	errorLog      *log.Logger
-//	infoLog       *log.Logger
-//	session       *sessions.Session
-//	templateCache map[string]*template.Template
-//	snippets      *mysql.SnippetModel
-//	users         *mysql.UserModel
-//}
+type userStore interface {
+	Insert(string, string, string) error
+	Authenticate(string, string) (int, error)
+	Get(int) (*models.User, error)
+	GetByEmail(string) (*models.User, error)
+	UpdateName(int, string) error
+	UpdateEmail(int, string) error
+	UpdatePassword(int, string) error
+	EnableTOTP(int, string, []string) error
+	DisableTOTP(int) error
+	AuthenticateTOTP(int, string) error
+	AuthenticateRecoveryCode(int, string) error
+	List(int) ([]*models.User, error)
+	Count() (int, int, error)
+	SetActive(int, bool) error
+	SetRole(int, string) error
+}
+
+var providerNameRX = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Policies of the brute-force protection for the login. The limit per IP address
+// is higher, because many users can share one address (for example, behind a NAT).
+var (
+	loginLockoutPolicy = lockout.Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+	ipLockoutPolicy = lockout.Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+)
 
 func main() {
-	// Обрабатываем конфигурационные параметры приложения
-	addr := flag.String("addr", ":4000", "HTTP network address")
-	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
-	secret := flag.String("secret", "s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge", "Secret key")
-	flag.Parse()
+	// Обрабатываем конфигурационные параметры приложения: файл, переменные окружения и флаги
+	cfg, err := loadConfig(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Инициализируем логгеры
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	logLevel, _ := logger.ParseLevel(cfg.LogLevel)
+	appLogger, err := logger.New(os.Stdout, cfg.LogFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Ключи сессионных куки проверяем до подключения к базе данных,
+	// чтобы с небезопасными настройками приложение не запускалось совсем
+	sessionKeys, err := loadSessionKeys(cfg.Secret, cfg.SecretFile, os.Getenv)
+	if err != nil {
+		appLogger.Fatal("session keys", "error", err)
+	}
+	err = checkSessionKeys(sessionKeys, cfg.Env)
+	if err != nil {
+		appLogger.Fatal("session keys", "error", err)
+	}
+
+	// Код выхода выставляется в конце main и применяется после того, как отработают
+	// отложенные функции (закрытие базы данных и файла трассировки). appLogger.Fatal
+	// вызывает os.Exit сразу и пропустил бы их
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 
 	// Инициализируем пул соединений с базой данных
-	db, err := openDB(*dsn)
+	db, err := openDB(cfg.DSN)
 	if err != nil {
-		errorLog.Fatal(err)
+		appLogger.Fatal("database", "error", err)
 	}
 	defer db.Close()
 
-	// Инициализируем кэш шаблонов веб-страниц приложения
-	templateCache, err := newTemplateCache("./ui/html/")
+	// Считаем хэши статических файлов, на которые ссылаются шаблоны,
+	// и инициализируем кэш шаблонов веб-страниц приложения
+	// Шаблоны и статические файлы встроены в бинарник. При разработке (-dev) они
+	// читаются с диска, чтобы изменения были видны без перезапуска приложения
+	var uiFiles fs.FS = ui.Files
+	if cfg.Dev {
+		uiFiles = os.DirFS("./ui")
+	}
+	htmlFiles, err := fs.Sub(uiFiles, "html")
 	if err != nil {
-		errorLog.Fatal(err)
+		appLogger.Fatal("UI files", "error", err)
+	}
+	staticFiles, err := fs.Sub(uiFiles, "static")
+	if err != nil {
+		appLogger.Fatal("UI files", "error", err)
+	}
+	assets, err := newAssetManifest(staticFiles, cfg.Dev)
+	if err != nil {
+		appLogger.Fatal("static files", "error", err)
+	}
+	templateCache, err := newTemplateCache(htmlFiles, assets)
+	if err != nil {
+		appLogger.Fatal("templates", "error", err)
 	}
 
 	// Use the sessions.New() function to initialize a new session manager,
-	// passing in the secret key as the parameter. Then we configure it so
-	// sessions always expires after 12 hours.
-	session := sessions.New([]byte(*secret))
-	session.Lifetime = 12 * time.Hour
-	session.Secure = true // Set the Secure flag on our session cookies
+	// passing in the active key and the old (decrypt-only) keys as the parameters.
+	// Then we configure it so sessions always expires after -session-lifetime (12 hours by default).
+	session := sessions.New(sessionKeys[0], sessionKeys[1:]...)
+	session.Lifetime = cfg.SessionLifetime
+	// Set the Secure flag on our session cookies. In the plain HTTP mode the flag is
+	// only set if the site is public over HTTPS (that is, a proxy terminates TLS).
+	session.Secure = !cfg.Server.PlainHTTP || strings.HasPrefix(cfg.BaseURL, "https://")
+
+	trustedProxies, err := parseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		appLogger.Fatal("trusted proxies", "error", err)
+	}
+
+	// Метрики в формате Prometheus, включая статистику пула соединений с базой данных
+	appMetrics := newAppMetrics()
+	appMetrics.registerDB(db)
+
+	// Ограничения частоты запросов для групп маршрутов (-rate-limit-*), счетчики хранятся в памяти
+	rateLimiters := map[string]*ratelimit.Limiter{}
+	for group, s := range cfg.rateLimits() {
+		limit, err := parseRateLimit(s)
+		if err != nil {
+			appLogger.Fatal("rate limit", "group", group, "error", err)
+		}
+		if limit != nil {
+			rateLimiters[group] = ratelimit.New(*limit)
+		}
+	}
+
+	// Трассировка запросов: спаны пишутся JSON-строками в stdout или в файл
+	var tracer *tracing.Tracer
+	switch cfg.Tracing.Exporter {
+	case "stdout":
+		tracer = tracing.New(tracing.NewWriterExporter(os.Stdout))
+	case "file":
+		f, err := os.OpenFile(cfg.Tracing.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			appLogger.Fatal("trace file", "error", err)
+		}
+		defer f.Close()
+		tracer = tracing.New(tracing.NewWriterExporter(f))
+	}
+
+	// Загружаем настройки внешних провайдеров аутентификации (OpenID Connect), если они заданы
+	var providers []*oidc.Provider
+	if cfg.OIDCConfig != "" {
+		providers, err = loadOIDCProviders(cfg.OIDCConfig)
+		if err != nil {
+			appLogger.Fatal("OpenID Connect providers", "error", err)
+		}
+	}
+
+	// Счетчики неудачных попыток входа храним либо в памяти (если запущен один экземпляр приложения),
+	// либо в базе данных (тогда они общие для всех экземпляров)
+	var attempts lockout.Store
+	switch cfg.LockoutStore {
+	case "memory":
+		attempts = lockout.NewMemoryStore(loginLockoutPolicy.ResetAfter)
+	case "mysql":
+		attempts = &mysql.LoginAttemptModel{DB: db}
+	default:
+		appLogger.Fatal("unknown lockout store", "store", cfg.LockoutStore)
+	}
 
 	// Инициализируем инстанс структуры application, который будет содержать все зависимости для handler-ов HTTP запросов
 	app := &application{
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		session:       session,
-		snippets:      &mysql.SnippetModel{DB: db},
-		templateCache: templateCache,
-		users:         &mysql.UserModel{DB: db},
+		accountGuard:   lockout.New(attempts, loginLockoutPolicy),
+		assets:         assets,
+		auditEvents:    &mysql.AuditModel{DB: db},
+		db:             db,
+		baseURL:        strings.TrimSuffix(cfg.BaseURL, "/"),
+		csp:            cfg.Security.CSP,
+		cspReportOnly:  cfg.Security.CSPReportOnly,
+		dev:            cfg.Dev,
+		hstsMaxAge:     cfg.Security.HSTSMaxAge,
+		htmlFiles:      htmlFiles,
+		identities:     &mysql.IdentityModel{DB: db},
+		logger:         appLogger,
+		ipGuard:        lockout.New(attempts, ipLockoutPolicy),
+		loginSessions:  &mysql.SessionModel{DB: db},
+		mailer:         &mailer.LogMailer{Log: appLogger.StdLogger(logger.LevelInfo)},
+		metrics:        appMetrics,
+		metricsAddr:    cfg.MetricsAddr,
+		oidcProviders:  providers,
+		rateLimiters:   rateLimiters,
+		session:        session,
+		snippets:       &mysql.SnippetModel{DB: db},
+		teams:          &mysql.TeamModel{DB: db},
+		templateCache:  templateCache,
+		tokens:         &mysql.TokenModel{DB: db},
+		tracer:         tracer,
+		trustedProxies: trustedProxies,
+		users:          &mysql.UserModel{DB: db},
+	}
+
+	// Если SMTP сервер не задан (например, при разработке), письма просто пишутся в лог
+	if cfg.SMTP.Host != "" {
+		app.mailer = mailer.New(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Sender)
 	}
 
 	// Initialize a tls.Config struct to hold the non-default TLS settings we want the server to use
-	//
-	// The tls.Config.PreferServerCipherSuites field controls whether the HTTPS connection should use
-	// Go’s favored cipher suites or the user’s favored cipher suites.
-	// By setting this to true — Go’s favored cipher suites are given preference
-	// and we help increase the likelihood that a strong cipher suite which also supports forward secrecy is used
-	//
-	// The tls.Config.CurvePreferences field lets us specify which elliptic curves should be given preference
-	// during the TLS handshake. Go supports a few elliptic curves, but as of Go 1.11 only
-	// tls.CurveP256 and tls.X25519 have assembly implementations. The others are very CPU intensive,
-	// so omitting them helps ensure that our server will remain performant under heavy loads.
-	tlsConfig := &tls.Config{
-		PreferServerCipherSuites: true,
-		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		appLogger.Fatal("TLS configuration", "error", err)
+	}
+
+	// Сертификат перечитывается с диска, когда файлы меняются, так что обновить его можно
+	// без перезапуска. При разработке можно обойтись без сертификата в ./tls: тогда
+	// генерируется самоподписанный сертификат, который живет только в памяти
+	switch {
+	case cfg.Server.PlainHTTP:
+	case cfg.Env == "development" && !fileExists(cfg.TLS.CertFile) && !fileExists(cfg.TLS.KeyFile):
+		cert, err := selfSignedCertificate("localhost", "127.0.0.1", "::1")
+		if err != nil {
+			appLogger.Fatal("self-signed certificate", "error", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		appLogger.Warn("no TLS certificate found, using a temporary self-signed one")
+	default:
+		certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, certCheckInterval, appLogger)
+		if err != nil {
+			appLogger.Fatal("TLS certificate", "error", err)
+		}
+		tlsConfig.GetCertificate = certs.GetCertificate
 	}
 
 	// Инициализация сервера и роутера на базе пакета net/http
 	srv := &http.Server{
-		Addr:         *addr,            // адрес и/или порт
-		ErrorLog:     errorLog,         // логгер для ошибок сервера
-		Handler:      app.routes(),     // что использовать в качестве handler запросов
-		TLSConfig:    tlsConfig,        // конфиги для TLS (HTTPS) соединения
-		IdleTimeout:  time.Minute,      // Таймаут сервера по всем запросам
-		ReadTimeout:  5 * time.Second,  // Таймаут сервера по всем запросам
-		WriteTimeout: 10 * time.Second, // Таймаут сервера по всем запросам
-	}
-
-	// Запуск сервера на базе пакета net/http
-	infoLog.Printf("Starting server on %s", *addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+		Addr:         cfg.Addr,                              // адрес и/или порт
+		ErrorLog:     appLogger.StdLogger(logger.LevelWarn), // логгер для ошибок сервера
+		Handler:      app.routes(),                          // что использовать в качестве handler запросов
+		TLSConfig:    tlsConfig,                             // конфиги для TLS (HTTPS) соединения
+		IdleTimeout:  cfg.Server.IdleTimeout,                // Таймаут сервера по всем запросам
+		ReadTimeout:  cfg.Server.ReadTimeout,                // Таймаут сервера по всем запросам
+		WriteTimeout: cfg.Server.WriteTimeout,               // Таймаут сервера по всем запросам
+	}
+
+	// Запуск сервера на базе пакета net/http. Сервер работает до сигнала SIGINT или SIGTERM,
+	// после чего дожидается завершения текущих запросов и фоновых задач
+	appLogger.Info("starting server", "addr", cfg.Addr)
+	err = app.serve(srv, cfg)
+	if err != nil {
+		appLogger.Error("server", "error", err)
+		exitCode = 1
+		return
+	}
+	appLogger.Info("stopped server")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -129,3 +404,39 @@ func openDB(dsn string) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// The loadOIDCProviders function reads the settings of the OpenID Connect providers
+// from a JSON file of the form {"providers": [{"name": ..., "issuer": ..., ...}]}.
+func loadOIDCProviders(path string) ([]*oidc.Provider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Providers []oidc.Config `json:"providers"`
+	}
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var providers []*oidc.Provider
+	seen := map[string]bool{}
+	for i, p := range cfg.Providers {
+		switch {
+		case !providerNameRX.MatchString(p.Name):
+			return nil, fmt.Errorf("%s: provider #%d: name must consist of lowercase letters, digits and dashes", path, i+1)
+		case seen[p.Name]:
+			return nil, fmt.Errorf("%s: provider %q is defined twice", path, p.Name)
+		case p.Issuer == "" || p.ClientID == "":
+			return nil, fmt.Errorf("%s: provider %q: issuer and client_id are required", path, p.Name)
+		}
+		seen[p.Name] = true
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		providers = append(providers, oidc.NewProvider(p, nil))
+	}
+	return providers, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Dimau/snippetbox/pkg/metrics"
+	"github.com/bmizerany/pat"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The route label of the requests which didn't match any route.
+const unmatchedRoute = "unmatched"
+
+// The methods which get their own method label. Any client can send a made-up
+// method, so all the others share the "OTHER" label, otherwise every one of them
+// would create new series which are kept forever.
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// The metricMethod function returns the method label of a request.
+func metricMethod(method string) string {
+	if metricMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// The appMetrics type holds the metrics of the application, which are exposed
+// on /metrics in the Prometheus text format.
+type appMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	inFlight        int64
+	panics          *metrics.Counter
+	snippetsCreated *metrics.Counter
+	loginFailures   *metrics.Counter
+	rateLimited     *metrics.Counter
+}
+
+func newAppMetrics() *appMetrics {
+	r := metrics.NewRegistry()
+	m := &appMetrics{
+		registry:        r,
+		requests:        r.NewCounter("http_requests_total", "Number of HTTP requests by route pattern and status code.", "method", "route", "code"),
+		requestDuration: r.NewHistogram("http_request_duration_seconds", "Latency of HTTP requests by route pattern.", metrics.DefaultBuckets, "method", "route"),
+		panics:          r.NewCounter("snippetbox_panics_recovered_total", "Number of panics recovered in the HTTP handlers."),
+		snippetsCreated: r.NewCounter("snippetbox_snippets_created_total", "Number of created snippets by visibility.", "visibility"),
+		loginFailures:   r.NewCounter("snippetbox_login_failures_total", "Number of failed login attempts."),
+		rateLimited:     r.NewCounter("snippetbox_rate_limited_total", "Number of requests rejected by the rate limits by route group.", "group"),
+	}
+	r.NewGaugeFunc("http_requests_in_flight", "Number of HTTP requests being served.", func() float64 {
+		return float64(atomic.LoadInt64(&m.inFlight))
+	})
+	return m
+}
+
+// The registerDB method adds the gauges of the database connection pool. The
+// statistics are read on every scrape.
+func (m *appMetrics) registerDB(db *sql.DB) {
+	m.registry.NewGaugeFunc("snippetbox_db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	m.registry.NewGaugeFunc("snippetbox_db_open_connections", "Number of open connections to the database.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	m.registry.NewGaugeFunc("snippetbox_db_in_use_connections", "Number of database connections in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	m.registry.NewGaugeFunc("snippetbox_db_idle_connections", "Number of idle database connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	m.registry.NewCounterFunc("snippetbox_db_wait_count_total", "Number of times a query waited for a free connection.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	m.registry.NewCounterFunc("snippetbox_db_wait_duration_seconds_total", "Total time spent waiting for a free connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+}
+
+// Обертка, которая считает запросы, их длительность и количество запросов в обработке.
+// Запросы группируются по шаблону маршрута (а не по URL), иначе каждый сниппет
+// порождал бы свою серию метрик. Шаблон записывает routeMux, когда находит маршрут.
+// По той же причине незнакомые методы считаются вместе (см. metricMethod).
+func (app *application) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&app.metrics.inFlight, 1)
+		defer atomic.AddInt64(&app.metrics.inFlight, -1)
+
+		start := time.Now()
+		r, route := withRouteHolder(r)
+		rw := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		method := metricMethod(r.Method)
+		app.metrics.requests.Inc(method, *route, strconv.Itoa(rw.statusCode()))
+		app.metrics.requestDuration.Observe(time.Since(start).Seconds(), method, *route)
+	})
+}
+
+// The withRouteHolder function returns the place in the context of the request where
+// routeMux records the route pattern, adding it if the request doesn't have one yet
+// (both the metrics and the tracing need the route).
+func withRouteHolder(r *http.Request) (*http.Request, *string) {
+	if route, ok := r.Context().Value(contextKeyRoute).(*string); ok {
+		return r, route
+	}
+	route := unmatchedRoute
+	return r.WithContext(context.WithValue(r.Context(), contextKeyRoute, &route)), &route
+}
+
+// The routeMux type wraps the router: the handlers it registers record the pattern
+// of their route for the metrics.
+type routeMux struct {
+	*pat.PatternServeMux
+}
+
+func (m routeMux) Get(pattern string, h http.Handler) {
+	m.PatternServeMux.Get(pattern, withRoute(pattern, h))
+}
+
+func (m routeMux) Post(pattern string, h http.Handler) {
+	m.PatternServeMux.Post(pattern, withRoute(pattern, h))
+}
+
+func withRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(contextKeyRoute).(*string); ok {
+			*route = pattern
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/snippet/1")
+	ts.get(t, "/snippet/4")
+	ts.get(t, "/missing/page")
+
+	// Made-up methods must not create new series.
+	for _, method := range []string{"FOO1", "FOO2"} {
+		req, err := http.NewRequest(method, ts.URL+"/snippet/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+	}
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "wrongPa$$word")
+	ts.postForm(t, "/user/login", form)
+
+	ts.logIn(t)
+	form = url.Values{}
+	form.Add("title", "Metrics")
+	form.Add("content", "Count me")
+	form.Add("expires", "7")
+	form.Add("visibility", "private")
+	code, _, _ := ts.postForm(t, "/snippet/create", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("create snippet: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	code, header, body := ts.get(t, "/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if ct := header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("want Prometheus content type; got %q", ct)
+	}
+
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/snippet/:id",code="200"} 2`,
+		`http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`http_requests_total{method="POST",route="/snippet/create",code="303"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/snippet/:id"} 2`,
+		`http_requests_in_flight 1`,
+		`snippetbox_login_failures_total 1`,
+		`snippetbox_snippets_created_total{visibility="private"} 1`,
+		`snippetbox_panics_recovered_total 0`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if !strings.Contains(string(body), `http_requests_total{method="OTHER",route="unmatched",`) {
+		t.Errorf("want the unknown methods counted as OTHER")
+	}
+	if strings.Contains(string(body), "FOO") {
+		t.Errorf("want no series for the unknown methods")
+	}
+}
+
+func TestMetricsOnSeparateListener(t *testing.T) {
+	app := newTestApplication(t)
+	app.metricsAddr = "127.0.0.1:9100"
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/metrics")
+	if code != http.StatusNotFound {
+		t.Errorf("want %d; got %d", http.StatusNotFound, code)
+	}
+}
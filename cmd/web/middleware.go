@@ -5,24 +5,131 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/tracing"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// The last use of a session is recorded in the database at most this often.
+const sessionTouchInterval = time.Minute
+
 // Обертка для обработчиков HTTP запросов, которая добавляет
-// в Headers параметры для защиты от XSS атак
-func secureHeaders(next http.Handler) http.Handler {
+// в Headers параметры для защиты от XSS атак и других атак через браузер.
+// Для каждого запроса генерируется новый nonce для Content Security Policy,
+// он кладется в контекст запроса, чтобы шаблоны могли его использовать.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
+		nonce, err := newCSPNonce()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// In the report-only mode violations of the policy are only reported, which
+		// is useful to try a new policy without breaking the site.
+		cspHeader := "Content-Security-Policy"
+		if app.cspReportOnly {
+			cspHeader = "Content-Security-Policy-Report-Only"
+		}
+		w.Header().Set(cspHeader, strings.ReplaceAll(app.csp, "{nonce}", nonce))
+
+		w.Header().Set("Referrer-Policy", "same-origin")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Permissions-Policy", permissionsPolicy)
+		w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
 		w.Header().Set("X-Frame-Options", "deny")
+		// The XSS auditors of the old browsers could be abused themselves, and the
+		// modern browsers don't have them at all. The CSP does this job now.
+		w.Header().Set("X-XSS-Protection", "0")
+
+		// HSTS only makes sense over HTTPS, browsers ignore it otherwise.
+		if isHTTPS(r) && app.hstsMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(app.hstsMaxAge.Seconds())))
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyCSPNonce, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Если приложение работает за reverse proxy, который терминирует TLS, то адрес клиента
+// и протокол запроса приходят в заголовках X-Forwarded-For и X-Forwarded-Proto.
+// Этим заголовкам можно верить только если запрос пришел от одного из доверенных прокси
+// (-trusted-proxies), иначе любой клиент мог бы подделать свой IP адрес.
+func (app *application) proxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isTrustedProxy(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Each proxy appends the address it got the request from, so the client is
+		// the rightmost address which isn't one of our proxies.
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addrs := strings.Split(strings.Join(forwarded, ","), ",")
+			for i := len(addrs) - 1; i >= 0; i-- {
+				ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+				if ip == nil {
+					break
+				}
+				r.RemoteAddr = ip.String()
+				if !app.isTrustedProxy(r.RemoteAddr) {
+					break
+				}
+			}
+		}
+
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			ctx := context.WithValue(r.Context(), contextKeyForwardedProto, strings.ToLower(proto))
+			r = r.WithContext(ctx)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// Обертка для обработчиков HTTP запросов, которая логирует ключевую информацию о запросе
+// Обертка, которая присваивает каждому запросу идентификатор. Он возвращается клиенту
+// в заголовке X-Request-ID и попадает во все записи лога о запросе, так что по нему
+// можно найти, что случилось с конкретным запросом. Если запрос пришел через доверенный
+// прокси, который уже присвоил ему идентификатор, используется этот идентификатор.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) || !app.isTrustedProxy(clientIP(r)) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Обертка для обработчиков HTTP запросов, которая логирует ключевую информацию о запросе.
+// Запись делается после ответа, чтобы в нее попали статус, размер ответа и время обработки.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		app.requestLogger(r).Info("request",
+			"remote_addr", r.RemoteAddr,
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rw.statusCode(),
+			"bytes", rw.bytes,
+			"duration", time.Since(start),
+		)
 	})
 }
 
@@ -38,10 +145,11 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				// Set a "Connection: close" header on the response.
 				w.Header().Set("Connection", "close")
+				app.metrics.panics.Inc()
 				// Call the app.serverError helper method to return a 500 Internal Server error
 				// функция recover() возвращает то, что было передано в функцию panic()
 				// Это может быть срока или error или что-то еще, мы приводим это к типу error
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -69,34 +177,122 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// Middleware обертка, которая пропускает поток управления к следующему обработчику только,
+// если у пользователя есть указанная роль (или роль с большими правами).
+// Она используется после requireAuthentication, поэтому пользователь уже известен.
+func (app *application) requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.authenticatedUser(r)
+		if user == nil {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		if !user.HasRole(role) {
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware обертка, которая ограничивает частоту запросов к группе маршрутов
+// (см. -rate-limit-*). Счетчик ведется для пользователя, если он вошел, иначе для
+// IP адреса клиента, поэтому она используется после authenticate.
+func (app *application) rateLimit(group string, next http.Handler) http.Handler {
+	limiter := app.rateLimiters[group]
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + clientIP(r)
+		if user := app.authenticatedUser(r); user != nil {
+			key = "user:" + strconv.Itoa(user.ID)
+		}
+		ok, wait := limiter.Allow(key)
+		if !ok {
+			app.metrics.rateLimited.Inc(group)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			app.clientError(w, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if a authenticatedUserID value exists in the session. If this *isn't
-		// present* then call the next handler in the chain as normal.
-		exists := app.session.Exists(r, "authenticatedUserID")
-		if !exists {
+		// Check if a sessionToken value exists in the session cookie. If this *isn't
+		// present*, try to restore the session with the "remember me" cookie. If there
+		// is nothing to restore, call the next handler in the chain as normal.
+		token := app.session.GetString(r, "sessionToken")
+		if token == "" {
+			var err error
+			token, err = app.restoreSession(w, r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+		if token == "" {
+			// Without a session the user can still be identified by the client certificate.
+			// Such requests have no server-side session, and they are authenticated again
+			// on every request, so logging out has no effect while the certificate is used.
+			user, err := app.clientCertificateUser(r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if user != nil {
+				tracing.FromContext(r.Context()).Set("user.id", user.ID)
+				ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+				ctx = context.WithValue(ctx, contextKeyAuthenticatedUser, user)
+				r = r.WithContext(ctx)
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Fetch the details of the current user from the database. If no matching
-		// record is found, or the current user is has been deactivated, remove the
-		// (invalid) authenticatedUserID value from their session and call the next handler in the chain as normal.
-		user, err := app.users.Get(app.session.GetInt(r, "authenticatedUserID"))
-		if errors.Is(err, models.ErrNoRecord) || !user.Active {
-			app.session.Remove(r, "authenticatedUserID")
+		// Fetch the server-side session and the current user from the database. If the
+		// session has expired or has been revoked (by logging out on another device,
+		// changing the password or deactivating the user), or the user has been deactivated,
+		// remove the (invalid) token from the session cookie and call the next handler in the chain as normal.
+		s, err := app.loginSessions.Get(token)
+		var user *models.User
+		if err == nil {
+			user, err = app.userModel(r).Get(s.UserID)
+		}
+		if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+			app.session.Remove(r, "sessionToken")
 			next.ServeHTTP(w, r)
 			return
 		} else if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 
+		// Remember when (and from where) the session has been used last time, so that
+		// the user can recognise it in the list of their sessions. There is no need
+		// to write to the database on every request, once a minute is accurate enough.
+		if time.Since(s.LastSeen) > sessionTouchInterval || s.IP != clientIP(r) {
+			err = app.loginSessions.Touch(s.ID, clientIP(r))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
 		// Otherwise, we know that the request is coming from an active, authenticated user.
 		// We create a new copy of the request, with a true boolean value
 		// added to the request context to indicate this, and call the next handler
 		// in the chain *using this new copy of the request*.
+		// The user and the session records are added to the context as well, so that handlers
+		// don't need to fetch them from the database again.
+		tracing.FromContext(r.Context()).Set("user.id", user.ID)
 		ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+		ctx = context.WithValue(ctx, contextKeyAuthenticatedUser, user)
+		ctx = context.WithValue(ctx, contextKeyLoginSession, s)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"github.com/Dimau/snippetbox/pkg/logger"
+	"github.com/Dimau/snippetbox/pkg/ratelimit"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -29,7 +36,8 @@ func TestSecureHeaders(t *testing.T) {
 	// Так как middleware возвращает в качестве результата своей работы новый HTTP handler
 	// (обертку над переданным), мы можем обратиться к методу ServeHTTP у возвращенного значения
 	// Нам важно проверить, как HTTP handler после middleware обертки будет работать с тестовым HTTP запросом
-	secureHeaders(next).ServeHTTP(rr, r)
+	app := newTestApplication(t)
+	app.secureHeaders(next).ServeHTTP(rr, r)
 
 	// ***** Validation *****
 	// Вызываем метод Result() у нашего http.ResponseRecorder, чтобы получить http.Response
@@ -41,10 +49,27 @@ func TestSecureHeaders(t *testing.T) {
 		t.Errorf("want %q; got %q", "deny", frameOptions)
 	}
 
-	// Проверяем, что middleware корректно установил заголовок "X-XSS-Protection" в HTTP Response
-	xssProtection := rs.Header.Get("X-XSS-Protection")
-	if xssProtection != "1; mode=block" {
-		t.Errorf("want %q; got %q", "1; mode=block", xssProtection)
+	// Проверяем, что middleware выключил устаревший XSS auditor и установил остальные заголовки
+	headers := map[string]string{
+		"X-XSS-Protection":           "0",
+		"Referrer-Policy":            "same-origin",
+		"X-Content-Type-Options":     "nosniff",
+		"Permissions-Policy":         permissionsPolicy,
+		"Cross-Origin-Opener-Policy": "same-origin",
+	}
+	for name, want := range headers {
+		if got := rs.Header.Get(name); got != want {
+			t.Errorf("%s: want %q; got %q", name, want, got)
+		}
+	}
+
+	// Проверяем, что в политику CSP подставлен nonce, а HSTS не отправляется по HTTP
+	csp := rs.Header.Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'self' 'nonce-") || strings.Contains(csp, "{nonce}") {
+		t.Errorf("want a nonce in the policy; got %q", csp)
+	}
+	if hsts := rs.Header.Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("want no Strict-Transport-Security over HTTP; got %q", hsts)
 	}
 
 	// Проверяем, что middleware корректно вызывал next HTTP handler
@@ -63,3 +88,213 @@ func TestSecureHeaders(t *testing.T) {
 		t.Errorf("want body to equal %q", "OK")
 	}
 }
+
+func TestProxyHeaders(t *testing.T) {
+	app := newTestApplication(t)
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.trustedProxies = proxies
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		proto      string
+		wantAddr   string
+		wantHSTS   bool
+	}{
+		{"Direct client", "203.0.113.7:5555", "", "", "203.0.113.7:5555", false},
+		{"Untrusted proxy", "203.0.113.7:5555", "198.51.100.1", "https", "203.0.113.7:5555", false},
+		{"Trusted proxy", "192.168.1.1:5555", "198.51.100.1", "https", "198.51.100.1", true},
+		{"Chain of proxies", "10.0.0.2:5555", "6.6.6.6, 198.51.100.1, 10.0.0.1", "https", "198.51.100.1", true},
+		{"Only proxies", "10.0.0.2:5555", "10.0.0.3, 10.0.0.1", "http", "10.0.0.3", false},
+		{"Malformed header", "10.0.0.2:5555", "198.51.100.1, unknown", "", "10.0.0.2:5555", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			var gotAddr string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAddr = r.RemoteAddr
+			})
+			rr := httptest.NewRecorder()
+			app.proxyHeaders(app.secureHeaders(next)).ServeHTTP(rr, r)
+
+			if gotAddr != tt.wantAddr {
+				t.Errorf("want remote address %q; got %q", tt.wantAddr, gotAddr)
+			}
+			if gotHSTS := rr.Header().Get("Strict-Transport-Security") != ""; gotHSTS != tt.wantHSTS {
+				t.Errorf("want HSTS %v; got %v", tt.wantHSTS, gotHSTS)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	proxies, err := parseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.trustedProxies = proxies
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		wantKept   bool
+	}{
+		{"No header", "203.0.113.7:5555", "", false},
+		{"Untrusted client", "203.0.113.7:5555", "spoofed-id", false},
+		{"Trusted proxy", "10.0.0.1:5555", "proxy-id-42", true},
+		{"Malformed header", "10.0.0.1:5555", "bad id\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = r.Context().Value(contextKeyRequestID).(string)
+			})
+			rr := httptest.NewRecorder()
+			app.requestID(next).ServeHTTP(rr, r)
+
+			if got == "" || rr.Header().Get("X-Request-ID") != got {
+				t.Fatalf("want the same request ID in the context and the header; got %q and %q", got, rr.Header().Get("X-Request-ID"))
+			}
+			if (got == tt.header) != tt.wantKept {
+				t.Errorf("want header kept %v; got ID %q", tt.wantKept, got)
+			}
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	app := newTestApplication(t)
+	var buf bytes.Buffer
+	testLogger, err := logger.New(&buf, logger.FormatJSON, logger.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.logger = testLogger
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBytes  int
+		wantError  bool
+	}{
+		{"OK", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, http.StatusOK, 5, false},
+		{"Not found", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }, http.StatusNotFound, 19, false},
+		{"Panic", func(w http.ResponseWriter, r *http.Request) { panic("oops") }, http.StatusInternalServerError, 22, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			r := httptest.NewRequest(http.MethodGet, "/snippet/1", nil)
+			rr := httptest.NewRecorder()
+			app.requestID(app.logRequest(app.recoverPanic(tt.handler))).ServeHTTP(rr, r)
+
+			var entries []map[string]interface{}
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var e map[string]interface{}
+				if err := dec.Decode(&e); err != nil {
+					t.Fatal(err)
+				}
+				entries = append(entries, e)
+			}
+			if tt.wantError {
+				if len(entries) != 2 || entries[0]["msg"] != "server error" || entries[0]["request_id"] != rr.Header().Get("X-Request-ID") {
+					t.Fatalf("want a server error entry with the request ID; got %v", entries)
+				}
+				entries = entries[1:]
+			}
+			if len(entries) != 1 {
+				t.Fatalf("want 1 entry; got %v", entries)
+			}
+
+			e := entries[0]
+			if e["msg"] != "request" || e["uri"] != "/snippet/1" || e["request_id"] != rr.Header().Get("X-Request-ID") {
+				t.Errorf("want request entry with the URI and the request ID; got %v", e)
+			}
+			if e["status"] != float64(tt.wantStatus) {
+				t.Errorf("want status %d; got %v", tt.wantStatus, e["status"])
+			}
+			if e["bytes"] != float64(tt.wantBytes) {
+				t.Errorf("want bytes %d; got %v", tt.wantBytes, e["bytes"])
+			}
+			if _, ok := e["duration"].(string); !ok {
+				t.Errorf("want duration; got %v", e["duration"])
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	now := time.Date(2021, 12, 18, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	app.rateLimiters = map[string]*ratelimit.Limiter{}
+	for _, group := range []string{"create", "signup"} {
+		limiter := ratelimit.New(ratelimit.Every(2, time.Minute))
+		limiter.Now = clock
+		app.rateLimiters[group] = limiter
+	}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The signups are limited per IP address
+	signup := url.Values{}
+	signup.Add("name", "Bob")
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		code, header, _ := ts.postForm(t, "/user/signup", signup)
+		if code != want {
+			t.Errorf("signup %d: want %d; got %d", i+1, want, code)
+		}
+		if want == http.StatusTooManyRequests && header.Get("Retry-After") != "30" {
+			t.Errorf("want Retry-After %q; got %q", "30", header.Get("Retry-After"))
+		}
+	}
+	now = now.Add(30 * time.Second)
+	if code, _, _ := ts.postForm(t, "/user/signup", signup); code != http.StatusOK {
+		t.Errorf("signup after waiting: want %d; got %d", http.StatusOK, code)
+	}
+
+	// The snippets are limited per user
+	snippet := url.Values{}
+	snippet.Add("title", "Rate limit")
+	snippet.Add("content", "Not too often")
+	snippet.Add("expires", "7")
+	snippet.Add("visibility", "public")
+	ts.logIn(t)
+	for i, want := range []int{http.StatusSeeOther, http.StatusSeeOther, http.StatusTooManyRequests} {
+		code, _, _ := ts.postForm(t, "/snippet/create", snippet)
+		if code != want {
+			t.Errorf("snippet %d: want %d; got %d", i+1, want, code)
+		}
+	}
+	ts.logInAs(t, "dave@example.com")
+	if code, _, _ := ts.postForm(t, "/snippet/create", snippet); code != http.StatusSeeOther {
+		t.Errorf("snippet of another user: want %d; got %d", http.StatusSeeOther, code)
+	}
+}
@@ -1,32 +1,79 @@
 package main
 
 import (
+	"github.com/Dimau/snippetbox/pkg/models"
 	"github.com/bmizerany/pat"
 	"net/http"
 )
 
 // Метод application для инициализации и настройки роутера
 func (app *application) routes() http.Handler {
-	mux := pat.New()
+	// routeMux запоминает шаблон маршрута каждого запроса для метрик
+	mux := routeMux{pat.New()}
 	// Все обработчики с динамическим контентом оборачиваем в middleware
 	// для чтения/записи сессионных куки "app.session.Enable"
 	mux.Get("/", app.session.Enable(app.authenticate(http.HandlerFunc(app.home))))
 	mux.Get("/snippet/create", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.createSnippetForm)))))
-	mux.Post("/snippet/create", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.createSnippet)))))
+	mux.Post("/snippet/create", app.session.Enable(app.authenticate(app.requireAuthentication(app.rateLimit("create", http.HandlerFunc(app.createSnippet))))))
 	mux.Get("/snippet/:id", app.session.Enable(app.authenticate(http.HandlerFunc(app.showSnippet))))
 	mux.Get("/user/signup", app.session.Enable(app.authenticate(http.HandlerFunc(app.signupUserForm))))
-	mux.Post("/user/signup", app.session.Enable(app.authenticate(http.HandlerFunc(app.signupUser))))
+	mux.Post("/user/signup", app.session.Enable(app.authenticate(app.rateLimit("signup", http.HandlerFunc(app.signupUser)))))
 	mux.Get("/user/login", app.session.Enable(app.authenticate(http.HandlerFunc(app.loginUserForm))))
 	mux.Post("/user/login", app.session.Enable(app.authenticate(http.HandlerFunc(app.loginUser))))
+	mux.Get("/user/login/oidc/:provider/callback", app.session.Enable(app.authenticate(http.HandlerFunc(app.oidcCallback))))
+	mux.Get("/user/login/oidc/:provider", app.session.Enable(app.authenticate(http.HandlerFunc(app.oidcLogin))))
+	mux.Get("/user/login/2fa", app.session.Enable(app.authenticate(http.HandlerFunc(app.loginTwoFactorForm))))
+	mux.Post("/user/login/2fa", app.session.Enable(app.authenticate(http.HandlerFunc(app.loginTwoFactor))))
+	mux.Get("/user/password/forgot", app.session.Enable(app.authenticate(http.HandlerFunc(app.forgotPasswordForm))))
+	mux.Post("/user/password/forgot", app.session.Enable(app.authenticate(app.rateLimit("email", http.HandlerFunc(app.forgotPassword)))))
+	mux.Get("/user/password/reset", app.session.Enable(app.authenticate(http.HandlerFunc(app.resetPasswordForm))))
+	mux.Post("/user/password/reset", app.session.Enable(app.authenticate(http.HandlerFunc(app.resetPassword))))
 	mux.Post("/user/logout", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.logoutUser)))))
-	mux.Get("/ping", http.HandlerFunc(ping))
+	mux.Get("/user/me", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.myProfile)))))
+	mux.Get("/user/:id", app.session.Enable(app.authenticate(http.HandlerFunc(app.userProfile))))
+	mux.Get("/account", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.account)))))
+	mux.Post("/account/sessions/revoke", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.revokeSession)))))
+	mux.Post("/account/sessions/revoke-others", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.revokeOtherSessions)))))
+	mux.Get("/account/name", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.updateNameForm)))))
+	mux.Post("/account/name", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.updateName)))))
+	mux.Get("/account/email/confirm", app.session.Enable(app.authenticate(http.HandlerFunc(app.confirmEmail))))
+	mux.Get("/account/email", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.updateEmailForm)))))
+	mux.Post("/account/email", app.session.Enable(app.authenticate(app.requireAuthentication(app.rateLimit("email", http.HandlerFunc(app.updateEmail))))))
+	mux.Get("/account/password", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.updatePasswordForm)))))
+	mux.Post("/account/password", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.updatePassword)))))
+	mux.Get("/account/2fa", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.twoFactor)))))
+	mux.Post("/account/2fa", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.enableTwoFactor)))))
+	mux.Get("/account/2fa/qr.png", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.twoFactorQRCode)))))
+	mux.Post("/account/2fa/disable", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.disableTwoFactor)))))
+	mux.Get("/teams", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.listTeams)))))
+	mux.Post("/teams", app.session.Enable(app.authenticate(app.requireAuthentication(app.rateLimit("create", http.HandlerFunc(app.createTeam))))))
+	mux.Get("/team/invitation", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.teamInvitationForm)))))
+	mux.Post("/team/invitation", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.acceptTeamInvitation)))))
+	mux.Get("/team/:id", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.showTeam)))))
+	mux.Post("/team/:id/invite", app.session.Enable(app.authenticate(app.requireAuthentication(app.rateLimit("email", http.HandlerFunc(app.inviteTeamMember))))))
+	mux.Post("/team/:id/leave", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.leaveTeam)))))
+	mux.Post("/team/:id/remove", app.session.Enable(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.removeTeamMember)))))
+	mux.Get("/admin", app.session.Enable(app.authenticate(app.requireAuthentication(app.requireRole(models.RoleModerator, http.HandlerFunc(app.adminDashboard))))))
+	mux.Post("/admin/snippet/delete", app.session.Enable(app.authenticate(app.requireAuthentication(app.requireRole(models.RoleModerator, http.HandlerFunc(app.adminDeleteSnippet))))))
+	mux.Post("/admin/user/active", app.session.Enable(app.authenticate(app.requireAuthentication(app.requireRole(models.RoleAdmin, http.HandlerFunc(app.adminSetUserActive))))))
+	mux.Get("/admin/audit", app.session.Enable(app.authenticate(app.requireAuthentication(app.requireRole(models.RoleAdmin, http.HandlerFunc(app.adminAuditLog))))))
+	mux.Get("/admin/audit/export", app.session.Enable(app.authenticate(app.requireAuthentication(app.requireRole(models.RoleAdmin, http.HandlerFunc(app.adminAuditExport))))))
+	mux.Post("/admin/user/role", app.session.Enable(app.authenticate(app.requireAuthentication(app.requireRole(models.RoleAdmin, http.HandlerFunc(app.adminSetUserRole))))))
 
-	// Обработчик для статических файлов
-	fileServer := http.FileServer(http.Dir("./ui/static/"))
-	mux.Get("/static/", http.StripPrefix("/static", fileServer))
+	mux.Get("/ping", http.HandlerFunc(app.ping))
+	mux.Get("/healthz", http.HandlerFunc(app.healthz))
+	mux.Get("/readyz", http.HandlerFunc(app.readyz))
+	// Метрики отдаются на отдельном адресе, если он задан (-metrics-addr)
+	if app.metricsAddr == "" {
+		mux.Get("/metrics", app.metrics.registry.Handler())
+	}
+	mux.Post("/csp-report", http.HandlerFunc(app.cspReport))
+
+	// Обработчик для статических файлов (в том числе по именам с хэшами)
+	mux.Get("/static/", http.StripPrefix("/static", app.assets.fileServer()))
 
 	// Возвращает мультиплексор (роутер), обернутый в несколько слоев middleware обработчиков
 	// Тем самым, сначала для каждого запроса последовательно отрабатывает логика каждого middleware
 	// А затем уже отрабатывает логика непосредственно роутера и обработчика
-	return app.recoverPanic(app.logRequest(secureHeaders(mux)))
+	return app.requestID(app.proxyHeaders(app.trace(app.logRequest(app.measure(app.recoverPanic(app.secureHeaders(app.compress(mux))))))))
 }
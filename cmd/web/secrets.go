@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// The secret which the -secret flag defaults to. It is fine for development,
+// but everybody can read it in the source code, so the application refuses to
+// start in production with it.
+const defaultSecret = "s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge"
+
+// The environment variable which can hold the session keys, separated by commas.
+const sessionKeysEnv = "SNIPPETBOX_SESSION_KEYS"
+
+// The session cookies are encrypted and authenticated with 32-byte keys.
+const sessionKeyLength = 32
+
+// The loadSessionKeys function returns the keys of the session cookies. The first
+// key is the active one: new cookies are encrypted with it. The other keys are
+// only used to decrypt the cookies which were issued before the last rotation.
+//
+// The keys are read from the first of these sources which is set:
+//   - the file at path file, one key per line (blank lines and lines starting with # are ignored);
+//   - the SNIPPETBOX_SESSION_KEYS environment variable, keys separated by commas;
+//   - the -secret flag, which holds a single key.
+//
+// The -secret flag is accepted with any length, as it always was, so that the
+// existing deployments keep their sessions; the new sources require exactly 32
+// characters.
+func loadSessionKeys(secret, file string, getenv func(string) string) ([][]byte, error) {
+	var keys [][]byte
+	var source string
+	switch {
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			keys = append(keys, []byte(line))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		source = file
+	case getenv(sessionKeysEnv) != "":
+		for _, key := range strings.Split(getenv(sessionKeysEnv), ",") {
+			key = strings.TrimSpace(key)
+			if key != "" {
+				keys = append(keys, []byte(key))
+			}
+		}
+		source = sessionKeysEnv
+	default:
+		// The sessions package pads a short key with zeros and truncates a long one,
+		// which is how the secret has always been used.
+		return [][]byte{[]byte(secret)}, nil
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no session keys", source)
+	}
+	for i, key := range keys {
+		// Shorter keys would be padded with zeros and longer ones truncated,
+		// which silently weakens them, so we require the exact length.
+		if len(key) != sessionKeyLength {
+			return nil, fmt.Errorf("%s: session key #%d must be %d characters long, not %d", source, i+1, sessionKeyLength, len(key))
+		}
+	}
+	return keys, nil
+}
+
+// The checkSessionKeys function refuses the built-in default secret in production,
+// both as the active key and as an old one.
+func checkSessionKeys(keys [][]byte, env string) error {
+	if env != "production" {
+		return nil
+	}
+	for _, key := range keys {
+		if string(key) == defaultSecret {
+			return errors.New("the built-in default session secret must not be used in production")
+		}
+	}
+	return nil
+}
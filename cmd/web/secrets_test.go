@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/golangcollege/sessions"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oldSessionKey = "3dSm5MnygFHh7XidAtbskXrjbwfoJcbJ"
+	newSessionKey = "Xq9wT2vLmN8rK4pZ7cB1hJ6gF3dS5aY0"
+)
+
+func TestLoadSessionKeys(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys")
+	err := ioutil.WriteFile(file, []byte("# active key\n"+newSessionKey+"\n\n# old keys\n"+oldSessionKey+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := filepath.Join(dir, "short")
+	err = ioutil.WriteFile(short, []byte("tooshort\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := func(value string) func(string) string {
+		return func(name string) string {
+			if name == sessionKeysEnv {
+				return value
+			}
+			return ""
+		}
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		getenv   func(string) string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{"Flag", "", env(""), []string{defaultSecret}, false},
+		{"Environment", "", env(newSessionKey + ", " + oldSessionKey), []string{newSessionKey, oldSessionKey}, false},
+		{"File", file, env(defaultSecret), []string{newSessionKey, oldSessionKey}, false},
+		{"Missing file", filepath.Join(dir, "missing"), env(""), nil, true},
+		{"Short key", short, env(""), nil, true},
+		{"Short key in environment", "", env("tooshort"), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := loadSessionKeys(defaultSecret, tt.file, tt.getenv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			var got []string
+			for _, key := range keys {
+				got = append(got, string(key))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("want %q; got %q", tt.wantKeys, got)
+			}
+		})
+	}
+}
+
+func TestLoadLegacySecret(t *testing.T) {
+	// Before the key rotation -secret was accepted with any length, and the
+	// existing deployments must keep working with it.
+	for _, secret := range []string{"short", defaultSecret + defaultSecret} {
+		keys, err := loadSessionKeys(secret, "", func(string) string { return "" })
+		if err != nil {
+			t.Fatalf("%q: want no error; got %v", secret, err)
+		}
+		if len(keys) != 1 || string(keys[0]) != secret {
+			t.Errorf("want %q; got %q", secret, keys)
+		}
+	}
+}
+
+func TestCheckSessionKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		env     string
+		wantErr bool
+	}{
+		{"Default secret in development", []string{defaultSecret}, "development", false},
+		{"Default secret in production", []string{defaultSecret}, "production", true},
+		{"Default secret as old key in production", []string{newSessionKey, defaultSecret}, "production", true},
+		{"Own secret in production", []string{newSessionKey, oldSessionKey}, "production", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys [][]byte
+			for _, key := range tt.keys {
+				keys = append(keys, []byte(key))
+			}
+			err := checkSessionKeys(keys, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	// Log in with the application which still uses the old key.
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.logIn(t)
+
+	// The cookie jar is keyed by the URL of the server, so we replay the cookies
+	// to the servers with the rotated keys by hand.
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := ts.Client().Jar.Cookies(u)
+	get := func(t *testing.T, session *sessions.Session) (int, string) {
+		app := newTestApplication(t)
+		session.Lifetime = 12 * time.Hour
+		session.Secure = true
+		app.session = session
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/account", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		return rs.StatusCode, rs.Header.Get("Location")
+	}
+
+	// During the rotation the new key is active and the old one is still accepted,
+	// so the cookie issued before the rotation keeps the user logged in.
+	code, _ := get(t, sessions.New([]byte(newSessionKey), []byte(oldSessionKey)))
+	if code != http.StatusOK {
+		t.Errorf("want %d with the old key kept; got %d", http.StatusOK, code)
+	}
+
+	// Once the old key is dropped, the old cookie doesn't work any more.
+	code, location := get(t, sessions.New([]byte(newSessionKey)))
+	if code != http.StatusSeeOther || location != "/user/login" {
+		t.Errorf("want redirect to %q without the old key; got %d %q", "/user/login", code, location)
+	}
+}
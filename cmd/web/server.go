@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The serve method runs the servers until the process receives SIGINT or SIGTERM:
+// the main one (over HTTPS, or plain HTTP with -plain-http), the one which
+// redirects to HTTPS (with -redirect-addr) and the one which serves the metrics
+// (with -metrics-addr). Then the servers shut down gracefully:
+//  1. /ping starts to report "draining", and for the -drain-delay the servers keep
+//     accepting requests, so that the load balancer has time to notice it;
+//  2. the listeners are closed and the in-flight requests are finished;
+//  3. the background functions (for example, sending emails) are finished.
+//
+// Steps 2 and 3 together are limited by the -drain-timeout. If one of the listeners
+// fails, the other servers are shut down in the same way (without the delay) and
+// the error of the listener is returned.
+func (app *application) serve(srv *http.Server, cfg *config) error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	servers := []*http.Server{srv}
+	serveErr := make(chan error, 3)
+	go func() {
+		if cfg.Server.PlainHTTP {
+			serveErr <- srv.ListenAndServe()
+			return
+		}
+		// The certificate is set up in srv.TLSConfig, so the file names are empty.
+		serveErr <- srv.ListenAndServeTLS("", "")
+	}()
+
+	if cfg.Server.RedirectAddr != "" {
+		redirect := &http.Server{
+			Addr:         cfg.Server.RedirectAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      http.HandlerFunc(app.redirectToHTTPS),
+			IdleTimeout:  srv.IdleTimeout,
+			ReadTimeout:  srv.ReadTimeout,
+			WriteTimeout: srv.WriteTimeout,
+		}
+		servers = append(servers, redirect)
+		app.logger.Info("redirecting HTTP requests", "addr", redirect.Addr, "base_url", app.baseURL)
+		go func() {
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.metrics.registry.Handler())
+		metricsSrv := &http.Server{
+			Addr:         cfg.MetricsAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      mux,
+			IdleTimeout:  srv.IdleTimeout,
+			ReadTimeout:  srv.ReadTimeout,
+			WriteTimeout: srv.WriteTimeout,
+		}
+		servers = append(servers, metricsSrv)
+		app.logger.Info("serving metrics", "addr", metricsSrv.Addr)
+		go func() {
+			serveErr <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		// One of the listeners has failed (for example, the port is taken).
+		app.logger.Error("listener failed, shutting down", "error", err)
+		if shutdownErr := app.shutdown(0, cfg.Server.DrainTimeout, servers...); shutdownErr != nil {
+			app.logger.Error("shutdown", "error", shutdownErr)
+		}
+		return err
+	case s := <-quit:
+		app.logger.Info("shutting down", "signal", s)
+		return app.shutdown(cfg.Server.DrainDelay, cfg.Server.DrainTimeout, servers...)
+	}
+}
+
+// The shutdown method drains and stops the servers, see serve. Every server is
+// shut down and the background tasks are waited for even if some of the servers
+// fail to stop, so that, for example, the emails which are being sent aren't
+// lost. The first error is returned, the others are logged.
+func (app *application) shutdown(delay, timeout time.Duration, servers ...*http.Server) error {
+	atomic.StoreInt32(&app.draining, 1)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		} else {
+			app.logger.Error("shutdown", "error", err)
+		}
+	}
+
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			fail(fmt.Errorf("server %s: %w", srv.Addr, err))
+		}
+	}
+
+	app.logger.Info("waiting for background tasks")
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// The deadline may have passed while the servers were stopping, after the
+		// tasks had finished.
+		select {
+		case <-done:
+		default:
+			fail(errors.New("background tasks didn't finish in time"))
+		}
+	}
+	return firstErr
+}
+
+// The redirectToHTTPS handler of the -redirect-addr listener sends the clients to
+// the same page on the -base-url. The Host header of the request isn't used, so
+// that the redirect can't be pointed to another site.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	http.Redirect(w, r, app.baseURL+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
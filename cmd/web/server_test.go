@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPingDraining(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	atomic.StoreInt32(&app.draining, 1)
+	code, _, body := ts.get(t, "/ping")
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("want %d; got %d", http.StatusServiceUnavailable, code)
+	}
+	if string(body) != "draining\n" {
+		t.Errorf("want body %q; got %q", "draining\n", body)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		taskTime time.Duration
+		wantErr  bool
+	}{
+		{"Background task finishes", 10 * time.Millisecond, false},
+		{"Background task is too slow", time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := httptest.NewServer(app.routes())
+			defer ts.Close()
+
+			var finished int32
+			stop := make(chan struct{})
+			defer close(stop)
+			app.background(func() {
+				select {
+				case <-time.After(tt.taskTime):
+					atomic.StoreInt32(&finished, 1)
+				case <-stop:
+				}
+			})
+
+			err := app.shutdown(0, 100*time.Millisecond, ts.Config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && atomic.LoadInt32(&finished) != 1 {
+				t.Error("want background task to be finished")
+			}
+			if atomic.LoadInt32(&app.draining) != 1 {
+				t.Error("want application to be draining")
+			}
+			if _, err := http.Get(ts.URL + "/ping"); err == nil {
+				t.Error("want server to be closed")
+			}
+		})
+	}
+}
+
+// Define a failingListener type, whose Close returns an error, so that the
+// server which uses it fails to shut down.
+type failingListener struct {
+	net.Listener
+}
+
+func (l failingListener) Close() error {
+	l.Listener.Close()
+	return errors.New("close failed")
+}
+
+func TestShutdownServerFails(t *testing.T) {
+	app := newTestApplication(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &http.Server{Handler: app.routes()}
+	go failing.Serve(failingListener{ln})
+	// Make sure that the server has started to serve before it is shut down.
+	rs, err := http.Get("http://" + ln.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	var finished int32
+	app.background(func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	err = app.shutdown(0, time.Second, failing, ts.Config)
+	if err == nil || !strings.Contains(err.Error(), "close failed") {
+		t.Errorf("want the error of the failed server; got %v", err)
+	}
+	// The other server is stopped and the background task is waited for anyway.
+	if _, err := http.Get(ts.URL + "/ping"); err == nil {
+		t.Error("want the other server to be closed")
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("want background task to be finished")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	app := newTestApplication(t)
+
+	r := httptest.NewRequest(http.MethodGet, "http://evil.example.com/snippet/1?x=y", nil)
+	rr := httptest.NewRecorder()
+	app.redirectToHTTPS(rr, r)
+
+	if rr.Code != http.StatusMovedPermanently {
+		t.Errorf("want %d; got %d", http.StatusMovedPermanently, rr.Code)
+	}
+	want := "https://snippetbox.test/snippet/1?x=y"
+	if got := rr.Header().Get("Location"); got != want {
+		t.Errorf("want location %q; got %q", want, got)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"github.com/Dimau/snippetbox/pkg/forms"
 	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/oidc"
 	"html/template"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"time"
 )
 
@@ -13,12 +15,45 @@ import (
 // At the moment it only contains one field, but we'll add more
 // to it as the build progresses.
 type templateData struct {
-	CurrentYear     int
-	Flash           string
-	Form            *forms.Form
-	IsAuthenticated bool
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
+	AuditEvents       []*models.AuditEvent
+	AuthenticatedUser *models.User
+	Counts            *counts
+	CSPNonce          string
+	CurrentYear       int
+	Flash             string
+	Form              *forms.Form
+	Invitation        *models.TeamInvitation
+	IsAuthenticated   bool
+	LoginSession      *models.Session
+	LoginSessions     []*models.Session
+	Members           []*models.TeamMember
+	OIDCProviders     []*oidc.Provider
+	Pagination        *pagination
+	RecoveryCodes     []string
+	Snippet           *models.Snippet
+	Snippets          []*models.Snippet
+	Team              *models.Team
+	Teams             []*models.Team
+	TOTPSecret        string
+	TOTPURI           string
+	User              *models.User
+	Users             []*models.User
+}
+
+// The pagination type holds the current page number and the numbers of the
+// previous and the next pages, which are 0 if there are no such pages.
+type pagination struct {
+	Page int
+	Prev int
+	Next int
+}
+
+// The counts type holds the numbers shown on the admin dashboard.
+type counts struct {
+	Users        int
+	ActiveUsers  int
+	Snippets     int
+	LiveSnippets int
 }
 
 // Create a humanDate function which returns a nicely formatted string representation of a time.Time object.
@@ -35,15 +70,19 @@ func humanDate(t time.Time) string {
 // custom template functions and the functions themselves.
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"roles":     func() []string { return models.Roles },
 }
 
-func newTemplateCache(dir string) (map[string]*template.Template, error) {
+// The newTemplateCache function parses all the templates in fsys (the html
+// directory of the UI files). The templates reference the static files through
+// the functions of the asset manifest.
+func newTemplateCache(fsys fs.FS, assets *assetManifest) (map[string]*template.Template, error) {
 	// Инициализируем map для хранения кэша шаблонов веб-приложения
 	cache := map[string]*template.Template{}
 
-	// С помощью функции filepath.Glob получаем массив путей ко всем файлам с расширением '.page.tmpl'
+	// С помощью функции fs.Glob получаем массив путей ко всем файлам с расширением '.page.tmpl'
 	// По сути массив всех шаблонов страниц веб-приложения
-	pages, err := filepath.Glob(filepath.Join(dir, "*.page.tmpl"))
+	pages, err := fs.Glob(fsys, "*.page.tmpl")
 	if err != nil {
 		return nil, err
 	}
@@ -51,23 +90,12 @@ func newTemplateCache(dir string) (map[string]*template.Template, error) {
 	// Проходим в цикле по каждой странице
 	for _, page := range pages {
 		// Достаем имя файла (например 'home.page.tmpl') из полного пути к файлу
-		name := filepath.Base(page)
+		name := path.Base(page)
 
-		// Парсим соответствующий файл с шаблоном страницы в template set
+		// Парсим соответствующий файл с шаблоном страницы, а также шаблоны всех layout-ов
+		// и partial файлов в template set.
 		// Функции для использования в шаблонах объявили заранее в глобальной переменной functions
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
-		if err != nil {
-			return nil, err
-		}
-
-		// С помощью метода ParseGlob добавляем в template set шаблоны всех layout-ов
-		ts, err = ts.ParseGlob(filepath.Join(dir, "*.layout.tmpl"))
-		if err != nil {
-			return nil, err
-		}
-
-		// С помощью метода ParseGlob добавляем в template set шаблоны всех partial файлов
-		ts, err = ts.ParseGlob(filepath.Join(dir, "*.partial.tmpl"))
+		ts, err := template.New(name).Funcs(functions).Funcs(assets.funcs()).ParseFS(fsys, page, "*.layout.tmpl", "*.partial.tmpl")
 		if err != nil {
 			return nil, err
 		}
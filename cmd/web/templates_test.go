@@ -1,10 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"github.com/Dimau/snippetbox/ui"
+	"html/template"
+	"io/fs"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
+// The newTestUI helper builds the asset manifest and the template cache from
+// the UI files embedded into the binary, exactly like the application does.
+func newTestUI() (*assetManifest, map[string]*template.Template, error) {
+	htmlFiles, err := fs.Sub(ui.Files, "html")
+	if err != nil {
+		return nil, nil, err
+	}
+	staticFiles, err := fs.Sub(ui.Files, "static")
+	if err != nil {
+		return nil, nil, err
+	}
+	assets, err := newAssetManifest(staticFiles, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache, err := newTemplateCache(htmlFiles, assets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return assets, cache, nil
+}
+
 func TestHumanDate(t *testing.T) {
 	// Создаем таблицу sub-tests (в рамках одного большого тест-кейса) - на основе массива структур
 	// Каждая структура представляет собой описание одного sub-test
@@ -44,3 +71,50 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestTemplateCache(t *testing.T) {
+	_, cache, err := newTestUI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every page of the embedded files is in the cache.
+	pages, err := fs.Glob(ui.Files, "html/*.page.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 || len(cache) != len(pages) {
+		t.Fatalf("want %d pages; got %d", len(pages), len(cache))
+	}
+	if _, ok := cache["home.page.tmpl"]; !ok {
+		t.Errorf("want home.page.tmpl in the cache")
+	}
+}
+
+func TestTemplateCacheDev(t *testing.T) {
+	// In development the static files are referenced by their own names, so
+	// that the changes show up without a restart.
+	assets, err := newAssetManifest(fstest.MapFS{"css/main.css": {Data: []byte("body {}")}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htmlFiles := fstest.MapFS{
+		"test.page.tmpl":      {Data: []byte(`{{template "base" .}}{{define "main"}}<link href='{{static "css/main.css"}}' integrity='{{integrity "css/main.css"}}'>{{end}}`)},
+		"base.layout.tmpl":    {Data: []byte(`{{define "base"}}{{template "main" .}}{{template "footer" .}}{{end}}`)},
+		"footer.partial.tmpl": {Data: []byte(`{{define "footer"}}{{end}}`)},
+	}
+	cache, err := newTemplateCache(htmlFiles, assets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = cache["test.page.tmpl"].Execute(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<link href='/static/css/main.css' integrity=''>`
+	if buf.String() != want {
+		t.Errorf("want %q; got %q", want, buf.String())
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
+	"github.com/Dimau/snippetbox/pkg/lockout"
+	"github.com/Dimau/snippetbox/pkg/logger"
 	"github.com/Dimau/snippetbox/pkg/models/mock"
 	"github.com/golangcollege/sessions"
 	"html"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 )
@@ -36,7 +39,7 @@ func extractCSRFToken(t *testing.T, body []byte) string {
 // application struct containing mocked dependencies.
 func newTestApplication(t *testing.T) *application {
 	// Create an instance of the template cache.
-	templateCache, err := newTemplateCache("./../../ui/html/")
+	assets, templateCache, err := newTestUI()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -46,17 +49,59 @@ func newTestApplication(t *testing.T) *application {
 	session.Lifetime = 12 * time.Hour
 	session.Secure = true
 
+	// The log of the tests is discarded.
+	testLogger, err := logger.New(ioutil.Discard, logger.FormatText, logger.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// Initialize the dependencies, using the mocks for the loggers and database models.
+	attempts := lockout.NewMemoryStore(time.Hour)
 	return &application{
-		errorLog:      log.New(ioutil.Discard, "", 0),
-		infoLog:       log.New(ioutil.Discard, "", 0),
+		accountGuard:  lockout.New(attempts, loginLockoutPolicy),
+		assets:        assets,
+		auditEvents:   &mock.AuditModel{},
+		baseURL:       "https://snippetbox.test",
+		csp:           defaultCSP,
+		db:            &testDB{},
+		hstsMaxAge:    365 * 24 * time.Hour,
+		identities:    &mock.IdentityModel{},
+		ipGuard:       lockout.New(attempts, ipLockoutPolicy),
+		logger:        testLogger,
+		loginSessions: &mock.SessionModel{},
+		mailer:        &testMailer{},
+		metrics:       newAppMetrics(),
 		session:       session,
 		snippets:      &mock.SnippetModel{},
+		teams:         &mock.TeamModel{},
 		templateCache: templateCache,
+		tokens:        &mock.TokenModel{},
 		users:         &mock.UserModel{},
 	}
 }
 
+// Define a testDB type which pretends to be the database for the health checks.
+type testDB struct {
+	err error
+}
+
+func (db *testDB) PingContext(ctx context.Context) error {
+	return db.err
+}
+
+// Define a testMailer type which remembers the emails instead of sending them.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (m *testMailer) Send(recipient, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, recipient)
+	return nil
+}
+
 // Define a custom testServer type which anonymously embeds a httptest.Server instance.
 type testServer struct {
 	*httptest.Server
@@ -123,3 +168,20 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status, headers and body.
 	return rs.StatusCode, rs.Header, body
 }
+
+// Create a logIn method which logs in the test client as the mock user Alice. The
+// session cookie is kept in the cookie jar of the client for subsequent requests.
+func (ts *testServer) logIn(t *testing.T) {
+	ts.logInAs(t, "alice@example.com")
+}
+
+// The logInAs method logs in the test client as the mock user with the given email.
+func (ts *testServer) logInAs(t *testing.T, email string) {
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "validPa$$word")
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/tracing"
+	"net/http"
+)
+
+// Обертка, которая начинает спан для каждого запроса. Если в запросе есть заголовок
+// traceparent (W3C Trace Context), спан продолжает трассу вызывающего сервиса.
+// Имя спана и атрибуты выставляются после обработки, когда известны маршрут и статус.
+func (app *application) trace(next http.Handler) http.Handler {
+	if app.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.tracer.StartRemote(r.Context(), "HTTP "+r.Method, tracing.Extract(r.Header))
+		r, route := withRouteHolder(r.WithContext(ctx))
+		rw := &responseRecorder{ResponseWriter: w}
+		defer span.End()
+
+		next.ServeHTTP(rw, r)
+
+		status := rw.statusCode()
+		span.SetName("HTTP " + r.Method + " " + *route)
+		span.Set("http.method", r.Method)
+		span.Set("http.route", *route)
+		span.Set("http.status_code", status)
+		if id, ok := r.Context().Value(contextKeyRequestID).(string); ok {
+			span.Set("request_id", id)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+		}
+	})
+}
+
+// The startSpan helper starts a child span of the request, or returns nil (on which
+// all the methods of the span do nothing) if tracing is off.
+func (app *application) startSpan(ctx context.Context, name string) *tracing.Span {
+	if app.tracer == nil {
+		return nil
+	}
+	_, span := app.tracer.Start(ctx, name)
+	return span
+}
+
+// The snippetModel and userModel helpers return the models for the handling of the
+// request. With tracing on, every query becomes a span of the request.
+func (app *application) snippetModel(r *http.Request) snippetStore {
+	if app.tracer == nil {
+		return app.snippets
+	}
+	return tracedSnippets{app: app, ctx: r.Context(), next: app.snippets}
+}
+
+func (app *application) userModel(r *http.Request) userStore {
+	if app.tracer == nil {
+		return app.users
+	}
+	return tracedUsers{app: app, ctx: r.Context(), next: app.users}
+}
+
+// The querySpan helper starts the span of a database query.
+func (app *application) querySpan(ctx context.Context, name string) *tracing.Span {
+	span := app.startSpan(ctx, name)
+	span.Set("db.system", "mysql")
+	return span
+}
+
+func endSpan(span *tracing.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+type tracedSnippets struct {
+	app  *application
+	ctx  context.Context
+	next snippetStore
+}
+
+func (m tracedSnippets) Insert(userID, teamID int, title, content, expires, visibility string) (int, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.Insert")
+	id, err := m.next.Insert(userID, teamID, title, content, expires, visibility)
+	endSpan(span, err)
+	return id, err
+}
+
+func (m tracedSnippets) Get(id int) (*models.Snippet, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.Get")
+	s, err := m.next.Get(id)
+	endSpan(span, err)
+	return s, err
+}
+
+func (m tracedSnippets) Latest() ([]*models.Snippet, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.Latest")
+	s, err := m.next.Latest()
+	endSpan(span, err)
+	return s, err
+}
+
+func (m tracedSnippets) Recent(limit int) ([]*models.Snippet, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.Recent")
+	s, err := m.next.Recent(limit)
+	endSpan(span, err)
+	return s, err
+}
+
+func (m tracedSnippets) PublicByUser(userID, limit, offset int) ([]*models.Snippet, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.PublicByUser")
+	s, err := m.next.PublicByUser(userID, limit, offset)
+	endSpan(span, err)
+	return s, err
+}
+
+func (m tracedSnippets) ByTeam(userID, teamID, limit, offset int) ([]*models.Snippet, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.ByTeam")
+	s, err := m.next.ByTeam(userID, teamID, limit, offset)
+	endSpan(span, err)
+	return s, err
+}
+
+func (m tracedSnippets) Count() (int, int, error) {
+	span := m.app.querySpan(m.ctx, "SnippetModel.Count")
+	total, live, err := m.next.Count()
+	endSpan(span, err)
+	return total, live, err
+}
+
+func (m tracedSnippets) Delete(id int) error {
+	span := m.app.querySpan(m.ctx, "SnippetModel.Delete")
+	err := m.next.Delete(id)
+	endSpan(span, err)
+	return err
+}
+
+type tracedUsers struct {
+	app  *application
+	ctx  context.Context
+	next userStore
+}
+
+func (m tracedUsers) Insert(name, email, password string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.Insert")
+	err := m.next.Insert(name, email, password)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) Authenticate(email, password string) (int, error) {
+	span := m.app.querySpan(m.ctx, "UserModel.Authenticate")
+	id, err := m.next.Authenticate(email, password)
+	endSpan(span, err)
+	return id, err
+}
+
+func (m tracedUsers) Get(id int) (*models.User, error) {
+	span := m.app.querySpan(m.ctx, "UserModel.Get")
+	u, err := m.next.Get(id)
+	endSpan(span, err)
+	return u, err
+}
+
+func (m tracedUsers) GetByEmail(email string) (*models.User, error) {
+	span := m.app.querySpan(m.ctx, "UserModel.GetByEmail")
+	u, err := m.next.GetByEmail(email)
+	endSpan(span, err)
+	return u, err
+}
+
+func (m tracedUsers) UpdateName(id int, name string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.UpdateName")
+	err := m.next.UpdateName(id, name)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) UpdateEmail(id int, email string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.UpdateEmail")
+	err := m.next.UpdateEmail(id, email)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) UpdatePassword(id int, password string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.UpdatePassword")
+	err := m.next.UpdatePassword(id, password)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) EnableTOTP(id int, secret string, recoveryCodes []string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.EnableTOTP")
+	err := m.next.EnableTOTP(id, secret, recoveryCodes)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) DisableTOTP(id int) error {
+	span := m.app.querySpan(m.ctx, "UserModel.DisableTOTP")
+	err := m.next.DisableTOTP(id)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) AuthenticateTOTP(id int, code string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.AuthenticateTOTP")
+	err := m.next.AuthenticateTOTP(id, code)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) AuthenticateRecoveryCode(id int, code string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.AuthenticateRecoveryCode")
+	err := m.next.AuthenticateRecoveryCode(id, code)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) List(limit int) ([]*models.User, error) {
+	span := m.app.querySpan(m.ctx, "UserModel.List")
+	u, err := m.next.List(limit)
+	endSpan(span, err)
+	return u, err
+}
+
+func (m tracedUsers) Count() (int, int, error) {
+	span := m.app.querySpan(m.ctx, "UserModel.Count")
+	total, active, err := m.next.Count()
+	endSpan(span, err)
+	return total, active, err
+}
+
+func (m tracedUsers) SetActive(id int, active bool) error {
+	span := m.app.querySpan(m.ctx, "UserModel.SetActive")
+	err := m.next.SetActive(id, active)
+	endSpan(span, err)
+	return err
+}
+
+func (m tracedUsers) SetRole(id int, role string) error {
+	span := m.app.querySpan(m.ctx, "UserModel.SetRole")
+	err := m.next.SetRole(id, role)
+	endSpan(span, err)
+	return err
+}
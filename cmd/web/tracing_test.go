@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/Dimau/snippetbox/pkg/tracing"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Define a spanRecorder type, which keeps the exported spans. The request span
+// ends after the response has been sent, so the tests wait for it.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.SpanData
+}
+
+func (r *spanRecorder) Export(s *tracing.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *spanRecorder) wait(t *testing.T, name string) *tracing.SpanData {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if s := r.find(name); s != nil {
+			return s
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("want span %q", name)
+	return nil
+}
+
+// The find method returns the last span with the given name.
+func (r *spanRecorder) find(name string) *tracing.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.spans) - 1; i >= 0; i-- {
+		if r.spans[i].Name == name {
+			return r.spans[i]
+		}
+	}
+	return nil
+}
+
+func TestTrace(t *testing.T) {
+	app := newTestApplication(t)
+	rec := &spanRecorder{}
+	app.tracer = tracing.New(rec)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.logIn(t)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	request := rec.wait(t, "HTTP GET /snippet/:id")
+	if request.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || request.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("want the request span to continue the trace of the caller; got trace %q, parent %q", request.TraceID, request.ParentID)
+	}
+	for key, want := range map[string]interface{}{
+		"http.method":      "GET",
+		"http.route":       "/snippet/:id",
+		"http.status_code": http.StatusOK,
+		"user.id":          1,
+	} {
+		if got := request.Attributes[key]; got != want {
+			t.Errorf("attribute %s: want %v; got %v", key, want, got)
+		}
+	}
+
+	for _, name := range []string{"UserModel.Get", "SnippetModel.Get", "render"} {
+		s := rec.find(name)
+		if s == nil {
+			t.Errorf("want span %q", name)
+			continue
+		}
+		if s.TraceID != request.TraceID || s.ParentID != request.SpanID {
+			t.Errorf("want %q to be a child of the request span; got trace %q, parent %q", name, s.TraceID, s.ParentID)
+		}
+	}
+	if s := rec.find("render"); s != nil && s.Attributes["template"] != "show.page.tmpl" {
+		t.Errorf("want template %q; got %v", "show.page.tmpl", s.Attributes["template"])
+	}
+}
+
+func TestTraceOff(t *testing.T) {
+	app := newTestApplication(t)
+	if _, ok := app.snippetModel(&http.Request{}).(tracedSnippets); ok {
+		t.Error("want the models without the tracing wrappers when tracing is off")
+	}
+}
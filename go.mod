@@ -5,10 +5,9 @@ go 1.17
 require (
 	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/golangcollege/sessions v1.2.0
+	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6
+	rsc.io/qr v0.2.0
 )
 
-require (
-	github.com/golangcollege/sessions v1.2.0 // indirect
-	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6 // indirect
-	golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
-)
+require golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
@@ -0,0 +1,120 @@
+// Package lockout slows down password guessing. It counts consecutive failed
+// attempts per key (for example, per client IP or per account) and makes the
+// client wait exponentially longer after each failure, up to a temporary lockout.
+package lockout
+
+import (
+	"time"
+)
+
+// Store keeps the failure counters. There are two implementations: MemoryStore
+// in this package (for a single instance of the application) and
+// mysql.LoginAttemptModel (shared by all the instances using the same database).
+type Store interface {
+	// Get returns the number of consecutive failures for the key and the time
+	// of the last one. An unknown key has zero failures.
+	Get(key string) (failures int, last time.Time, err error)
+	// Fail increments the number of failures for the key, sets the time of the
+	// last failure and returns the new number of failures.
+	Fail(key string, at time.Time) (failures int, err error)
+	// Reset forgets the failures for the key.
+	Reset(key string) error
+}
+
+// Policy describes how fast the delay grows.
+type Policy struct {
+	// FreeAttempts is the number of failures allowed without any delay.
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure above FreeAttempts. It
+	// doubles with every further failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay. Reaching it means the key is locked out.
+	MaxDelay time.Duration
+	// ResetAfter is the time after the last failure when the counter starts from zero again.
+	ResetAfter time.Duration
+}
+
+// Guard applies a Policy to the counters in a Store.
+type Guard struct {
+	Store  Store
+	Policy Policy
+	// Now returns the current time. It can be replaced in tests.
+	Now func() time.Time
+}
+
+// New returns a Guard which uses the given store and policy.
+func New(store Store, policy Policy) *Guard {
+	return &Guard{Store: store, Policy: policy, Now: time.Now}
+}
+
+// Wait returns how long the client has to wait before the next attempt for the
+// key is allowed. Zero means that the attempt may go ahead.
+func (g *Guard) Wait(key string) (time.Duration, error) {
+	failures, last, err := g.Store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	now := g.Now()
+	if failures == 0 || now.Sub(last) >= g.Policy.ResetAfter {
+		return 0, nil
+	}
+
+	wait := last.Add(g.delay(failures)).Sub(now)
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// Fail records a failed attempt for the key. It returns the delay which now
+// applies to the key and whether this failure has locked the key out.
+func (g *Guard) Fail(key string) (delay time.Duration, locked bool, err error) {
+	now := g.Now()
+
+	// Counters which haven't been touched for a long time start from zero.
+	failures, last, err := g.Store.Get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	if failures > 0 && now.Sub(last) >= g.Policy.ResetAfter {
+		err = g.Store.Reset(key)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	failures, err = g.Store.Fail(key, now)
+	if err != nil {
+		return 0, false, err
+	}
+
+	delay = g.delay(failures)
+	locked = delay == g.Policy.MaxDelay && g.delay(failures-1) < g.Policy.MaxDelay
+	return delay, locked, nil
+}
+
+// Reset forgets the failures for the key, for example after a successful login.
+func (g *Guard) Reset(key string) error {
+	return g.Store.Reset(key)
+}
+
+// delay returns the delay after the given number of consecutive failures.
+func (g *Guard) delay(failures int) time.Duration {
+	n := failures - g.Policy.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+
+	delay := g.Policy.BaseDelay
+	for i := 1; i < n; i++ {
+		delay *= 2
+		if delay >= g.Policy.MaxDelay {
+			return g.Policy.MaxDelay
+		}
+	}
+	if delay > g.Policy.MaxDelay {
+		return g.Policy.MaxDelay
+	}
+	return delay
+}
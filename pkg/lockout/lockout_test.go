@@ -0,0 +1,153 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+// Define a fakeClock type, which lets the tests move the time forward.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestGuard() (*Guard, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)}
+	g := New(NewMemoryStore(24*time.Hour), Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		ResetAfter:   24 * time.Hour,
+	})
+	g.Now = clock.Now
+	return g, clock
+}
+
+func TestGuardBackoff(t *testing.T) {
+	g, _ := newTestGuard()
+
+	// The first three failures are free, then the delay doubles every time
+	// until it reaches the maximum (the lockout).
+	tests := []struct {
+		name       string
+		wantDelay  time.Duration
+		wantLocked bool
+	}{
+		{"1st failure", 0, false},
+		{"2nd failure", 0, false},
+		{"3rd failure", 0, false},
+		{"4th failure", time.Second, false},
+		{"5th failure", 2 * time.Second, false},
+		{"6th failure", 4 * time.Second, false},
+		{"7th failure", 8 * time.Second, false},
+		{"8th failure", 16 * time.Second, false},
+		{"9th failure", 32 * time.Second, false},
+		{"10th failure", time.Minute, true},
+		{"11th failure", time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, locked, err := g.Fail("alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if delay != tt.wantDelay || locked != tt.wantLocked {
+				t.Errorf("want %v, %t; got %v, %t", tt.wantDelay, tt.wantLocked, delay, locked)
+			}
+		})
+	}
+}
+
+func TestGuardWait(t *testing.T) {
+	g, clock := newTestGuard()
+
+	for i := 0; i < 5; i++ {
+		_, _, err := g.Fail("alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// After five failures the client must wait two seconds.
+	wait, err := g.Wait("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 2*time.Second {
+		t.Errorf("want %v; got %v", 2*time.Second, wait)
+	}
+
+	// Other keys are not affected.
+	wait, err = g.Wait("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("want %v; got %v", time.Duration(0), wait)
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+	wait, _ = g.Wait("alice@example.com")
+	if wait != 500*time.Millisecond {
+		t.Errorf("want %v; got %v", 500*time.Millisecond, wait)
+	}
+
+	clock.Advance(time.Second)
+	wait, _ = g.Wait("alice@example.com")
+	if wait != 0 {
+		t.Errorf("want %v; got %v", time.Duration(0), wait)
+	}
+
+	// The counter is still there, so the next failure increases the delay further.
+	delay, _, _ := g.Fail("alice@example.com")
+	if delay != 4*time.Second {
+		t.Errorf("want %v; got %v", 4*time.Second, delay)
+	}
+
+	// A day later the counter starts from zero again.
+	clock.Advance(24 * time.Hour)
+	delay, _, _ = g.Fail("alice@example.com")
+	if delay != 0 {
+		t.Errorf("want %v; got %v", time.Duration(0), delay)
+	}
+}
+
+func TestGuardReset(t *testing.T) {
+	g, _ := newTestGuard()
+
+	for i := 0; i < 5; i++ {
+		g.Fail("alice@example.com")
+	}
+	err := g.Reset("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait, _ := g.Wait("alice@example.com")
+	if wait != 0 {
+		t.Errorf("want %v; got %v", time.Duration(0), wait)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	start := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+
+	s.Fail("10.0.0.1", start)
+	s.Fail("10.0.0.2", start.Add(2*time.Hour))
+
+	if _, ok := s.entries["10.0.0.1"]; ok {
+		t.Errorf("want the idle key to be evicted")
+	}
+	if _, ok := s.entries["10.0.0.2"]; !ok {
+		t.Errorf("want the fresh key to be kept")
+	}
+}
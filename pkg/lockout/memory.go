@@ -0,0 +1,81 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures int
+	last     time.Time
+}
+
+// MemoryStore keeps the counters in memory. Counters which haven't been updated
+// for longer than the ttl are evicted, so the map doesn't grow without bounds
+// when an attacker cycles through many IP addresses or email addresses.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore which forgets the counters after ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]*entry{},
+		ttl:     ttl,
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return e.failures, e.last, nil
+}
+
+// Fail implements Store.
+func (s *MemoryStore) Fail(key string, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(at)
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &entry{}
+		s.entries[key] = e
+	}
+	e.failures++
+	e.last = at
+	return e.failures, nil
+}
+
+// Reset implements Store.
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep evicts the stale entries. It runs at most once per ttl, so the cost
+// is spread over many calls. The caller must hold the mutex.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	for key, e := range s.entries {
+		if now.Sub(e.last) >= s.ttl {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
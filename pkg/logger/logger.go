@@ -0,0 +1,248 @@
+// Package logger implements a small structured, leveled logger. Every entry is
+// written as a single line, either as key=value text or as a JSON object, with
+// the time, the level, the message and any number of key/value attributes.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of an entry. Entries below the level of the logger are dropped.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel returns the level with the given name: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for level, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// The output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// The time format of the entries: RFC 3339 with milliseconds.
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// The key which is used for an attribute without a valid key.
+const badKey = "!BADKEY"
+
+// Logger writes the entries. The loggers returned by With share the output with
+// their parent, and all of them are safe for concurrent use.
+type Logger struct {
+	out   *output
+	json  bool
+	level Level
+	attrs []interface{}
+}
+
+type output struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// New returns a logger which writes the entries of the given level and above
+// to w in the given format: FormatText or FormatJSON.
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &Logger{
+		out:   &output{w: w, now: time.Now},
+		json:  format == FormatJSON,
+		level: level,
+	}, nil
+}
+
+// With returns a logger which adds the key/value pairs to every entry.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	attrs := make([]interface{}, 0, len(l.attrs)+len(keyvals))
+	attrs = append(attrs, l.attrs...)
+	attrs = append(attrs, keyvals...)
+	return &Logger{out: l.out, json: l.json, level: l.level, attrs: attrs}
+}
+
+// Enabled reports whether the entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes an entry with the message and the key/value pairs, for example
+// l.Log(LevelInfo, "user logged in", "user_id", 42).
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	e := entry{json: l.json}
+	e.add("time", l.out.now().Format(timeFormat))
+	e.add("level", level.String())
+	e.add("msg", msg)
+	e.addPairs(l.attrs)
+	e.addPairs(keyvals)
+	e.end()
+	l.out.w.Write(e.buf.Bytes())
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.Log(LevelDebug, msg, keyvals...) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.Log(LevelInfo, msg, keyvals...) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.Log(LevelWarn, msg, keyvals...) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.Log(LevelError, msg, keyvals...) }
+
+// Fatal writes an entry at the error level and exits the program, like log.Fatal.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+	os.Exit(1)
+}
+
+// StdLogger returns a log.Logger which writes every line as an entry of the
+// level. It is meant for the packages which need a log.Logger, such as the
+// ErrorLog of http.Server.
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(stdWriter{l, level}, "", 0)
+}
+
+type stdWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.Log(w.level, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// entry builds a single line of the log.
+type entry struct {
+	json bool
+	buf  bytes.Buffer
+}
+
+func (e *entry) add(key string, value interface{}) {
+	if e.json {
+		if e.buf.Len() == 0 {
+			e.buf.WriteByte('{')
+		} else {
+			e.buf.WriteByte(',')
+		}
+		e.buf.WriteString(strconv.Quote(key))
+		e.buf.WriteByte(':')
+		e.buf.Write(jsonValue(value))
+		return
+	}
+
+	if e.buf.Len() > 0 {
+		e.buf.WriteByte(' ')
+	}
+	e.buf.WriteString(key)
+	e.buf.WriteByte('=')
+	e.buf.WriteString(textValue(value))
+}
+
+// The addPairs method adds the key/value pairs. A key which isn't a string (or
+// a value without a key) is logged under !BADKEY, so that nothing is lost.
+func (e *entry) addPairs(keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i++ {
+		key, ok := keyvals[i].(string)
+		if !ok || i+1 == len(keyvals) {
+			e.add(badKey, keyvals[i])
+			continue
+		}
+		e.add(key, keyvals[i+1])
+		i++
+	}
+}
+
+func (e *entry) end() {
+	if e.json {
+		e.buf.WriteByte('}')
+	}
+	e.buf.WriteByte('\n')
+}
+
+// The simplify function turns the values which have a natural text form (errors,
+// durations, times and other fmt.Stringers) into strings.
+func simplify(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(timeFormat)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func jsonValue(v interface{}) []byte {
+	js, err := json.Marshal(simplify(v))
+	if err != nil {
+		js, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return js
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(simplify(v))
+	if v == nil {
+		s = "<nil>"
+	}
+	if needsQuoting(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
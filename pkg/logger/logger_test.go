@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, format string, level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l, err := New(&buf, format, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.out.now = func() time.Time { return time.Date(2021, 12, 17, 10, 15, 0, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestText(t *testing.T) {
+	l, buf := newTestLogger(t, FormatText, LevelInfo)
+
+	l.With("request_id", "abc").Info("request", "status", 200, "duration", 1500*time.Microsecond, "uri", "/?q=a b")
+	l.Error("failed", "error", errors.New(`no "quotes"`), "odd")
+	l.Debug("dropped")
+
+	want := `time=2021-12-17T10:15:00.000Z level=INFO msg=request request_id=abc status=200 duration=1.5ms uri="/?q=a b"` + "\n" +
+		`time=2021-12-17T10:15:00.000Z level=ERROR msg=failed error="no \"quotes\"" !BADKEY=odd` + "\n"
+	if buf.String() != want {
+		t.Errorf("want %q; got %q", want, buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, buf := newTestLogger(t, FormatJSON, LevelDebug)
+
+	l.With("request_id", "abc").Debug("request", "status", 200, "error", errors.New("boom"), "user", nil)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time":       "2021-12-17T10:15:00.000Z",
+		"level":      "DEBUG",
+		"msg":        "request",
+		"request_id": "abc",
+		"status":     float64(200),
+		"error":      "boom",
+		"user":       nil,
+	}
+	if len(got) != len(want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("want %s=%v; got %v", k, v, got[k])
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"warn", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	l, buf := newTestLogger(t, FormatText, LevelInfo)
+
+	l.StdLogger(LevelWarn).Printf("http: TLS handshake error from %s", "1.2.3.4:5")
+
+	want := `time=2021-12-17T10:15:00.000Z level=WARN msg="http: TLS handshake error from 1.2.3.4:5"` + "\n"
+	if buf.String() != want {
+		t.Errorf("want %q; got %q", want, buf.String())
+	}
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Define a Mailer type which holds the settings of the SMTP server
+// we use to send emails (password reset links and so on).
+type Mailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// The New function initializes a Mailer which sends emails through the given SMTP server.
+// If username is empty, no authentication is performed.
+func New(host string, port int, username, password, sender string) *Mailer {
+	m := &Mailer{
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		sender: sender,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// The Send method sends a plain-text email with the given subject and body to the recipient.
+func (m *Mailer) Send(recipient, subject, body string) error {
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, message(m.sender, recipient, subject, body))
+}
+
+// LogMailer is used in development when no SMTP server is configured.
+// Instead of sending the emails it writes them to the given logger.
+type LogMailer struct {
+	Log *log.Logger
+}
+
+// The Send method writes the email to the log.
+func (m *LogMailer) Send(recipient, subject, body string) error {
+	m.Log.Printf("Email to %s: %s\n%s", recipient, subject, body)
+	return nil
+}
+
+// message builds an RFC 5322 message with the minimal set of headers.
+func message(sender, recipient, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", sender)
+	fmt.Fprintf(&b, "To: %s\r\n", recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
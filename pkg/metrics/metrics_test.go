@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("http_requests_total", "Number of HTTP requests.", "method", "code")
+	requests.Inc("GET", "200")
+	requests.Inc("GET", "200")
+	requests.Add(3, "POST", "303")
+
+	r.NewCounter("panics_total", "Number of panics.")
+
+	inFlight := r.NewGauge("in_flight", "Requests in flight.")
+	inFlight.Add(2)
+	inFlight.Add(-1)
+
+	r.NewGaugeFunc("connections", "Open connections.", func() float64 { return 4 })
+
+	latency := r.NewHistogram("latency_seconds", "Latency with \"quotes\" and a \\.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, `/snippet/"id"`)
+	latency.Observe(0.5, `/snippet/"id"`)
+	latency.Observe(5, `/snippet/"id"`)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP http_requests_total Number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",code="200"} 2
+http_requests_total{method="POST",code="303"} 3
+# HELP panics_total Number of panics.
+# TYPE panics_total counter
+panics_total 0
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 4
+# HELP latency_seconds Latency with "quotes" and a \\.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/snippet/\"id\"",le="0.1"} 1
+latency_seconds_bucket{route="/snippet/\"id\"",le="1"} 2
+latency_seconds_bucket{route="/snippet/\"id\"",le="+Inf"} 3
+latency_seconds_sum{route="/snippet/\"id\""} 5.55
+latency_seconds_count{route="/snippet/\"id\""} 3
+`
+	if buf.String() != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic for a duplicate name")
+		}
+	}()
+	r.NewGauge("requests_total", "Requests.")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("want Prometheus content type; got %q", ct)
+	}
+	if !bytes.Contains(rr.Body.Bytes(), []byte("requests_total 1\n")) {
+		t.Errorf("want requests_total 1; got %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,57 @@
+package mock
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"sync"
+	"time"
+)
+
+// Unlike the other mocks, AuditModel remembers the events, so that the tests
+// can check what has been recorded.
+type AuditModel struct {
+	mu     sync.Mutex
+	Events []*models.AuditEvent
+}
+
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = len(m.Events) + 1
+	e.Created = time.Now()
+	m.Events = append(m.Events, e)
+	return nil
+}
+
+// The Actions method returns the actions of the recorded events, the oldest first.
+func (m *AuditModel) Actions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actions := []string{}
+	for _, e := range m.Events {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+func (m *AuditModel) Recent(limit int) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := []*models.AuditEvent{}
+	for i := len(m.Events) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, m.Events[i])
+	}
+	return events, nil
+}
+
+func (m *AuditModel) Each(fn func(*models.AuditEvent) error) error {
+	m.mu.Lock()
+	events := append([]*models.AuditEvent{}, m.Events...)
+	m.mu.Unlock()
+	for _, e := range events {
+		err := fn(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package mock
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+)
+
+type IdentityModel struct{}
+
+func (m *IdentityModel) Get(issuer, subject string) (int, error) {
+	switch subject {
+	case "alice-subject":
+		return 1, nil
+	default:
+		return 0, models.ErrNoRecord
+	}
+}
+
+func (m *IdentityModel) Insert(issuer, subject string, userID int) error {
+	return nil
+}
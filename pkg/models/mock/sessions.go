@@ -0,0 +1,90 @@
+package mock
+
+import (
+	"fmt"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"time"
+)
+
+type SessionModel struct{}
+
+// The mock session token is "session-<user ID>", and the ID of the session is
+// the ID of the user. Every user also has another session with the ID 99.
+func (m *SessionModel) New(userID int, ip, userAgent string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("session-%d", userID), nil
+}
+
+func (m *SessionModel) Get(token string) (*models.Session, error) {
+	var userID int
+	_, err := fmt.Sscanf(token, "session-%d", &userID)
+	if err != nil {
+		return nil, models.ErrNoRecord
+	}
+	return &models.Session{
+		ID:        userID,
+		UserID:    userID,
+		IP:        "127.0.0.1",
+		UserAgent: "Go-http-client/1.1",
+		Created:   time.Now(),
+		LastSeen:  time.Now(),
+	}, nil
+}
+
+func (m *SessionModel) Touch(id int, ip string) error {
+	return nil
+}
+
+func (m *SessionModel) List(userID int) ([]*models.Session, error) {
+	current, _ := m.Get(fmt.Sprintf("session-%d", userID))
+	other := &models.Session{
+		ID:        99,
+		UserID:    userID,
+		IP:        "192.0.2.1",
+		UserAgent: "Mozilla/5.0 (Other Device)",
+		Created:   time.Now().Add(-time.Hour),
+		LastSeen:  time.Now().Add(-time.Hour),
+	}
+	return []*models.Session{current, other}, nil
+}
+
+func (m *SessionModel) Revoke(userID, id int) error {
+	switch id {
+	case userID, 99:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SessionModel) RevokeOthers(userID, keep int) error {
+	return nil
+}
+
+// The mock remember token is "remember-<user ID>:valid". It is rotated to
+// "remember-<user ID>:rotated", and the validator "stolen" is treated as reused.
+func (m *SessionModel) Remember(token string, ttl time.Duration) (string, error) {
+	s, err := m.Get(token)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("remember-%d:valid", s.UserID), nil
+}
+
+func (m *SessionModel) Restore(rememberToken string, ttl time.Duration) (string, string, error) {
+	var userID int
+	var validator string
+	_, err := fmt.Sscanf(rememberToken, "remember-%d:%s", &userID, &validator)
+	if err != nil {
+		return "", "", models.ErrNoRecord
+	}
+	switch validator {
+	case "valid":
+		return fmt.Sprintf("session-%d", userID), fmt.Sprintf("remember-%d:rotated", userID), nil
+	case "previous":
+		return "", "", models.ErrTokenRotated
+	case "stolen":
+		return "", "", models.ErrTokenReuse
+	default:
+		return "", "", models.ErrNoRecord
+	}
+}
@@ -11,11 +11,49 @@ var mockSnippet = &models.Snippet{
 	Content: "An old silent pond...",
 	Created: time.Now(),
 	Expires: time.Now(),
+	UserID: 1,
+	Visibility: models.VisibilityPublic,
+}
+
+// Alice also has a private and an unlisted snippet.
+var mockPrivateSnippet = &models.Snippet{
+	ID:         3,
+	Title:      "A private diary",
+	Content:    "Dear diary...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     1,
+	Visibility: models.VisibilityPrivate,
+}
+
+var mockUnlistedSnippet = &models.Snippet{
+	ID:         4,
+	Title:      "A secret link",
+	Content:    "Only for friends...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     1,
+	Visibility: models.VisibilityUnlisted,
+}
+
+// Erin has shared a snippet with the Core team.
+var mockTeamSnippet = &models.Snippet{
+	ID:         5,
+	Title:      "Team notes",
+	Content:    "For the team only...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     4,
+	TeamID:     1,
+	Visibility: models.VisibilityTeam,
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+func (m *SnippetModel) Insert(userID, teamID int, title, content, expires, visibility string) (int, error) {
+	if teamID != 0 && teamID != 1 {
+		return 0, models.ErrNotTeamMember
+	}
 	return 2, nil
 }
 
@@ -23,6 +61,12 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
+	case 3:
+		return mockPrivateSnippet, nil
+	case 4:
+		return mockUnlistedSnippet, nil
+	case 5:
+		return mockTeamSnippet, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
@@ -32,3 +76,34 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	return []*models.Snippet{mockSnippet}, nil
 }
 
+
+func (m *SnippetModel) Recent(limit int) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) Count() (int, int, error) {
+	return 1, 1, nil
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) PublicByUser(userID, limit, offset int) ([]*models.Snippet, error) {
+	if userID == 1 && offset == 0 {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) ByTeam(userID, teamID, limit, offset int) ([]*models.Snippet, error) {
+	if teamID != 1 || (userID != 1 && userID != 4) {
+		return nil, models.ErrNotTeamMember
+	}
+	return []*models.Snippet{mockTeamSnippet}, nil
+}
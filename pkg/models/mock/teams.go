@@ -0,0 +1,124 @@
+package mock
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"strings"
+	"time"
+)
+
+// Alice owns the Core team, Erin is a member of it.
+var mockTeam = &models.Team{
+	ID:      1,
+	Name:    "Core",
+	Created: time.Now(),
+}
+
+var mockTeamMembers = []*models.TeamMember{
+	{UserID: 1, Name: "Alice", Role: models.TeamRoleOwner, Joined: time.Now()},
+	{UserID: 4, Name: "Erin", Role: models.TeamRoleMember, Joined: time.Now()},
+}
+
+// The invitation "invite-dave" is for Dave.
+var mockTeamInvitation = &models.TeamInvitation{
+	TeamID:   1,
+	TeamName: "Core",
+	Email:    "dave@example.com",
+}
+
+type TeamModel struct{}
+
+// The mockTeamRole function returns the role of the user in the Core team, or "" if the user isn't in it.
+func mockTeamRole(teamID, userID int) string {
+	if teamID != 1 {
+		return ""
+	}
+	for _, tm := range mockTeamMembers {
+		if tm.UserID == userID {
+			return tm.Role
+		}
+	}
+	return ""
+}
+
+func (m *TeamModel) Create(userID int, name string) (int, error) {
+	return 2, nil
+}
+
+func (m *TeamModel) Get(userID, teamID int) (*models.Team, error) {
+	r := mockTeamRole(teamID, userID)
+	if r == "" {
+		return nil, models.ErrNotTeamMember
+	}
+	t := *mockTeam
+	t.Role = r
+	return &t, nil
+}
+
+func (m *TeamModel) ForUser(userID int) ([]*models.Team, error) {
+	t, err := m.Get(userID, 1)
+	if err != nil {
+		return []*models.Team{}, nil
+	}
+	return []*models.Team{t}, nil
+}
+
+func (m *TeamModel) IsMember(teamID, userID int) (bool, error) {
+	return mockTeamRole(teamID, userID) != "", nil
+}
+
+func (m *TeamModel) Members(userID, teamID int) ([]*models.TeamMember, error) {
+	if mockTeamRole(teamID, userID) == "" {
+		return nil, models.ErrNotTeamMember
+	}
+	return mockTeamMembers, nil
+}
+
+func (m *TeamModel) Invite(userID, teamID int, email string, ttl time.Duration) (string, error) {
+	switch mockTeamRole(teamID, userID) {
+	case models.TeamRoleOwner:
+		return "invite-token", nil
+	case models.TeamRoleMember:
+		return "", models.ErrNotTeamOwner
+	default:
+		return "", models.ErrNotTeamMember
+	}
+}
+
+func (m *TeamModel) Invitation(token string) (*models.TeamInvitation, error) {
+	if token == "invite-dave" {
+		return mockTeamInvitation, nil
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *TeamModel) Accept(token string, userID int, email string) (int, error) {
+	if token == "invite-dave" && strings.EqualFold(email, mockTeamInvitation.Email) {
+		return mockTeamInvitation.TeamID, nil
+	}
+	return 0, models.ErrNoRecord
+}
+
+func (m *TeamModel) Leave(userID, teamID int) error {
+	switch mockTeamRole(teamID, userID) {
+	case models.TeamRoleOwner:
+		return models.ErrLastTeamOwner
+	case models.TeamRoleMember:
+		return nil
+	default:
+		return models.ErrNotTeamMember
+	}
+}
+
+func (m *TeamModel) RemoveMember(userID, teamID, memberID int) error {
+	switch mockTeamRole(teamID, userID) {
+	case models.TeamRoleOwner:
+		if mockTeamRole(teamID, memberID) != models.TeamRoleMember {
+			return models.ErrNoRecord
+		}
+		return nil
+	case models.TeamRoleMember:
+		return models.ErrNotTeamOwner
+	default:
+		return models.ErrNotTeamMember
+	}
+}
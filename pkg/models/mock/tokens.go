@@ -0,0 +1,23 @@
+package mock
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"time"
+)
+
+type TokenModel struct{}
+
+func (m *TokenModel) New(userID int, ttl time.Duration, scope, data string) (string, error) {
+	return "VALIDTOKEN", nil
+}
+
+func (m *TokenModel) Consume(plaintext, scope string) (*models.Token, error) {
+	switch plaintext {
+	case "VALIDTOKEN":
+		return &models.Token{UserID: 1, Scope: scope, Data: "alice@new.example.com"}, nil
+	case "DUPETOKEN":
+		return &models.Token{UserID: 1, Scope: scope, Data: "dupe@example.com"}, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
@@ -6,11 +6,52 @@ import (
 )
 
 var mockUser = &models.User{
-	ID: 1,
-	Name: "Alice",
-	Email: "alice@example.com",
+	ID:      1,
+	Name:    "Alice",
+	Email:   "alice@example.com",
 	Created: time.Now(),
-	Active: true,
+	Active:  true,
+	Role:    models.RoleUser,
+}
+
+// Carol has turned on two-factor authentication.
+var mockTwoFactorUser = &models.User{
+	ID:               2,
+	Name:             "Carol",
+	Email:            "carol@example.com",
+	Created:          time.Now(),
+	Active:           true,
+	TwoFactorEnabled: true,
+	Role:             models.RoleUser,
+}
+
+// Dave is an administrator, Erin is a moderator.
+var mockAdminUser = &models.User{
+	ID:      3,
+	Name:    "Dave",
+	Email:   "dave@example.com",
+	Created: time.Now(),
+	Active:  true,
+	Role:    models.RoleAdmin,
+}
+
+var mockModeratorUser = &models.User{
+	ID:      4,
+	Name:    "Erin",
+	Email:   "erin@example.com",
+	Created: time.Now(),
+	Active:  true,
+	Role:    models.RoleModerator,
+}
+
+// Frank has been deactivated.
+var mockInactiveUser = &models.User{
+	ID:      5,
+	Name:    "Frank",
+	Email:   "frank@example.com",
+	Created: time.Now(),
+	Active:  false,
+	Role:    models.RoleUser,
 }
 
 type UserModel struct{}
@@ -25,9 +66,15 @@ func (m *UserModel) Insert(name, email, password string) error {
 }
 
 func (m *UserModel) Authenticate(email, password string) (int, error) {
-	switch email {
-	case "alice@example.com":
+	switch {
+	case email == "alice@example.com" && password == "validPa$$word":
 		return 1, nil
+	case email == "carol@example.com" && password == "validPa$$word":
+		return 2, nil
+	case email == "dave@example.com" && password == "validPa$$word":
+		return 3, nil
+	case email == "erin@example.com" && password == "validPa$$word":
+		return 4, nil
 	default:
 		return 0, models.ErrInvalidCredentials
 	}
@@ -37,7 +84,84 @@ func (m *UserModel) Get(id int) (*models.User, error) {
 	switch id {
 	case 1:
 		return mockUser, nil
+	case 2:
+		return mockTwoFactorUser, nil
+	case 3:
+		return mockAdminUser, nil
+	case 4:
+		return mockModeratorUser, nil
+	case 5:
+		return mockInactiveUser, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) List(limit int) ([]*models.User, error) {
+	return []*models.User{mockUser, mockTwoFactorUser, mockAdminUser, mockModeratorUser}, nil
+}
+
+func (m *UserModel) Count() (int, int, error) {
+	return 4, 4, nil
+}
+
+func (m *UserModel) SetActive(id int, active bool) error {
+	return nil
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+	return nil
+}
+
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	switch email {
+	case "alice@example.com", "dupe@example.com":
+		return mockUser, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
+
+func (m *UserModel) UpdateName(id int, name string) error {
+	return nil
+}
+
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	switch email {
+	case "dupe@example.com":
+		return models.ErrDuplicateEmail
+	default:
+		return nil
+	}
+}
+
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) EnableTOTP(id int, secret string, recoveryCodes []string) error {
+	return nil
+}
+
+func (m *UserModel) DisableTOTP(id int) error {
+	return nil
+}
+
+func (m *UserModel) AuthenticateTOTP(id int, code string) error {
+	if id == 2 && code == "123456" {
+		return nil
+	}
+	return models.ErrInvalidCredentials
+}
+
+func (m *UserModel) AuthenticateRecoveryCode(id int, code string) error {
+	if id == 2 && code == "abcde-fghij" {
+		return nil
+	}
+	return models.ErrInvalidCredentials
+}
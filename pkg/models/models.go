@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -14,6 +15,67 @@ var (
 	// Add a new ErrDuplicateEmail error. We'll use this later if a user
 	// tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// Ошибка - если "remember me" токен предъявлен повторно после того, как он уже
+	// был заменен новым. Так бывает, если токен украли, поэтому все сессии пользователя удаляются.
+	ErrTokenReuse = errors.New("models: remember token reused")
+	// Ошибка - если предъявлен предыдущий "remember me" токен вскоре после его замены.
+	// Так бывает, когда браузер отправляет несколько запросов одновременно, и это не кража.
+	ErrTokenRotated = errors.New("models: remember token just rotated")
+	// Ошибки проверки прав внутри команды: пользователь не состоит в команде,
+	// или действие доступно только владельцу команды.
+	ErrNotTeamMember = errors.New("models: not a member of the team")
+	ErrNotTeamOwner  = errors.New("models: not an owner of the team")
+	// Ошибка - если последний владелец пытается покинуть команду.
+	ErrLastTeamOwner = errors.New("models: the last owner can't leave the team")
+)
+
+// Области применения (scope) одноразовых токенов, которые мы высылаем пользователю по email
+const (
+	ScopePasswordReset = "password-reset"
+	ScopeEmailChange   = "email-change"
+)
+
+// Роли пользователей. Каждая следующая роль включает в себя права предыдущей:
+// модератор может удалять чужие заметки, администратор также управляет пользователями.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles in the order of increasing rights.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// Видимость заметок. Публичные заметки видны всем и показываются в списках,
+// заметки "по ссылке" видны всем, кто знает их адрес, но в списки не попадают,
+// личные заметки видны только их автору, командные - только участникам команды.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+	VisibilityTeam     = "team"
+)
+
+// Роли участников команды. Владелец может приглашать и удалять участников.
+const (
+	TeamRoleOwner  = "owner"
+	TeamRoleMember = "member"
+)
+
+// Действия, которые записываются в журнал аудита.
+const (
+	AuditSignup         = "user.signup"
+	AuditLogin          = "user.login"
+	AuditLoginFailed    = "user.login_failed"
+	AuditLogout         = "user.logout"
+	AuditPasswordChange = "user.password_change"
+	AuditPasswordReset  = "user.password_reset"
+	AuditSnippetCreate  = "snippet.create"
+	AuditSnippetDelete  = "admin.snippet_delete"
+	AuditUserActivate   = "admin.user_activate"
+	AuditUserDeactivate = "admin.user_deactivate"
+	AuditUserRoleChange = "admin.user_role_change"
+	AuditExport         = "admin.audit_export"
 )
 
 type Snippet struct {
@@ -22,13 +84,99 @@ type Snippet struct {
 	Content string
 	Created time.Time
 	Expires time.Time
+	// UserID is the ID of the author, or 0 for the snippets created before
+	// the snippets had authors.
+	UserID int
+	// TeamID is the ID of the team which owns the snippet, or 0.
+	TeamID     int
+	Visibility string
 }
 
 type User struct {
-	ID int
-	Name string
-	Email string
+	ID             int
+	Name           string
+	Email          string
 	HashedPassword []byte
+	Created        time.Time
+	Active         bool
+	// TwoFactorEnabled is true if the user has to enter a TOTP code when logging in.
+	TwoFactorEnabled bool
+	// Role is one of RoleUser, RoleModerator or RoleAdmin.
+	Role string
+}
+
+// HasRole reports whether the user has the given role or a role with more rights.
+func (u *User) HasRole(role string) bool {
+	return roleRank(role) > 0 && roleRank(u.Role) >= roleRank(role)
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Token holds the details of a single-use token which was sent to a user by email.
+// Data keeps an optional payload, for example the new email address for ScopeEmailChange.
+type Token struct {
+	UserID int
+	Scope  string
+	Data   string
+}
+
+// Session holds the details of a server-side login session. The secret token of
+// the session is kept in the cookie only, the database stores its hash.
+type Session struct {
+	ID        int
+	UserID    int
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// Team holds the details of a team. Role is the role of the user for whom
+// the team has been fetched.
+type Team struct {
+	ID      int
+	Name    string
 	Created time.Time
-	Active bool
-}
\ No newline at end of file
+	Role    string
+}
+
+// TeamMember holds the details of a member of a team.
+type TeamMember struct {
+	UserID int
+	Name   string
+	Role   string
+	Joined time.Time
+}
+
+// TeamInvitation holds the details of an invitation to join a team.
+type TeamInvitation struct {
+	TeamID   int
+	TeamName string
+	Email    string
+}
+
+// AuditEvent is a record of the audit log. ActorID is the ID of the user who
+// did it, or 0 if nobody was logged in. Details is a JSON object. The events
+// are exported as JSON, hence the tags.
+type AuditEvent struct {
+	ID        int             `json:"id"`
+	Created   time.Time       `json:"time"`
+	ActorID   int             `json:"actor_id,omitempty"`
+	ActorName string          `json:"actor_name,omitempty"`
+	Action    string          `json:"action"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	Details   json.RawMessage `json:"details"`
+}
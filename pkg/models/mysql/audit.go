@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/Dimau/snippetbox/pkg/models"
+)
+
+// Define an AuditModel type which wraps a sql.DB connection pool. The audit log
+// is append-only, so there are no methods to change or delete the events (and
+// the database refuses to do it anyway, see the triggers on audit_events).
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// The Insert method appends an event to the audit log. An empty Details is stored as {}.
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+	details := string(e.Details)
+	if details == "" {
+		details = "{}"
+	}
+
+	stmt := `INSERT INTO audit_events (created, actor_id, action, ip, user_agent, details)
+	VALUES(UTC_TIMESTAMP(), NULLIF(?, 0), ?, ?, LEFT(?, 255), ?)`
+	_, err := m.DB.Exec(stmt, e.ActorID, e.Action, e.IP, e.UserAgent, details)
+	return err
+}
+
+// The Recent method returns the most recent events, at most limit of them.
+func (m *AuditModel) Recent(limit int) ([]*models.AuditEvent, error) {
+	stmt := `SELECT e.id, e.created, COALESCE(e.actor_id, 0), COALESCE(u.name, ''), e.action, e.ip, e.user_agent, e.details
+	FROM audit_events e LEFT JOIN users u ON u.id = e.actor_id
+	ORDER BY e.id DESC LIMIT ?`
+
+	events := []*models.AuditEvent{}
+	err := m.each(func(e *models.AuditEvent) error {
+		events = append(events, e)
+		return nil
+	}, stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// The Each method calls fn for every event of the audit log, the oldest first,
+// without loading the whole log into memory. It stops at the first error.
+func (m *AuditModel) Each(fn func(*models.AuditEvent) error) error {
+	stmt := `SELECT e.id, e.created, COALESCE(e.actor_id, 0), COALESCE(u.name, ''), e.action, e.ip, e.user_agent, e.details
+	FROM audit_events e LEFT JOIN users u ON u.id = e.actor_id
+	ORDER BY e.id`
+
+	return m.each(fn, stmt)
+}
+
+// The each helper runs a SELECT statement which returns events and calls fn for each of them.
+func (m *AuditModel) each(fn func(*models.AuditEvent) error, stmt string, args ...interface{}) error {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		var details []byte
+		err = rows.Scan(&e.ID, &e.Created, &e.ActorID, &e.ActorName, &e.Action, &e.IP, &e.UserAgent, &details)
+		if err != nil {
+			return err
+		}
+		e.Details = details
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,64 @@
+package mysql
+
+import (
+	"encoding/json"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"testing"
+)
+
+func TestAuditModel(t *testing.T) {
+	// Skip the test if the `-short` flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := AuditModel{db}
+
+	err := m.Insert(&models.AuditEvent{Action: models.AuditLoginFailed, IP: "192.0.2.1", UserAgent: "curl", Details: json.RawMessage(`{"email":"bob@example.com"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Insert(&models.AuditEvent{ActorID: 1, Action: models.AuditLogin, IP: "192.0.2.1", UserAgent: "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := m.Recent(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want %d events; got %d", 2, len(events))
+	}
+	if events[0].Action != models.AuditLogin || events[0].ActorName != "Alice Jones" {
+		t.Errorf("want the login of Alice Jones first; got %s of %q", events[0].Action, events[0].ActorName)
+	}
+	if string(events[0].Details) != "{}" {
+		t.Errorf("want details %q; got %q", "{}", events[0].Details)
+	}
+
+	var actions []string
+	err = m.Each(func(e *models.AuditEvent) error {
+		actions = append(actions, e.Action)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 || actions[0] != models.AuditLoginFailed {
+		t.Errorf("want the oldest event first; got %v", actions)
+	}
+
+	// The events can't be changed or deleted.
+	_, err = db.Exec(`UPDATE audit_events SET action = 'nothing'`)
+	if err == nil {
+		t.Errorf("want an error when updating an event")
+	}
+	_, err = db.Exec(`DELETE FROM audit_events`)
+	if err == nil {
+		t.Errorf("want an error when deleting an event")
+	}
+}
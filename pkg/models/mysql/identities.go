@@ -0,0 +1,37 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Dimau/snippetbox/pkg/models"
+)
+
+// Define an IdentityModel type which wraps a sql.DB connection pool. It links the
+// accounts at external identity providers (identified by the issuer and the
+// subject of their ID tokens) to our users.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// The Get method returns the ID of the user linked to the given identity, or
+// models.ErrNoRecord if the identity hasn't been seen before.
+func (m *IdentityModel) Get(issuer, subject string) (int, error) {
+	var userID int
+	stmt := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// The Insert method links the given identity to the user.
+func (m *IdentityModel) Insert(issuer, subject string, userID int) error {
+	stmt := `INSERT INTO user_identities (issuer, subject, user_id, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, issuer, subject, userID)
+	return err
+}
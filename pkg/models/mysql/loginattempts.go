@@ -0,0 +1,60 @@
+package mysql
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Define a LoginAttemptModel type which wraps a sql.DB connection pool. It keeps
+// the counters of failed login attempts and implements the lockout.Store
+// interface, so that all the instances of the application share the counters.
+//
+// The keys are stored as SHA-256 hashes: the email part of a key is whatever the
+// client has typed in, so it may be longer than the column, and truncating it
+// would let different keys share a counter.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+func attemptKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// The Get method returns the number of consecutive failures for the key and the time of the last one.
+func (m *LoginAttemptModel) Get(key string) (int, time.Time, error) {
+	var failures int
+	var last time.Time
+	stmt := `SELECT failures, last_failure FROM login_attempts WHERE attempt_key = ?`
+	err := m.DB.QueryRow(stmt, attemptKey(key)).Scan(&failures, &last)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, nil
+		} else {
+			return 0, time.Time{}, err
+		}
+	}
+	return failures, last, nil
+}
+
+// The Fail method increments the number of failures for the key and returns the new value.
+func (m *LoginAttemptModel) Fail(key string, at time.Time) (int, error) {
+	stmt := `INSERT INTO login_attempts (attempt_key, failures, last_failure) VALUES(?, 1, ?)
+	ON DUPLICATE KEY UPDATE failures = failures + 1, last_failure = VALUES(last_failure)`
+	_, err := m.DB.Exec(stmt, attemptKey(key), at.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	failures, _, err := m.Get(key)
+	return failures, err
+}
+
+// The Reset method deletes the counter for the key.
+func (m *LoginAttemptModel) Reset(key string) error {
+	_, err := m.DB.Exec(`DELETE FROM login_attempts WHERE attempt_key = ?`, attemptKey(key))
+	return err
+}
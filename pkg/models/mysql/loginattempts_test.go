@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptModel(t *testing.T) {
+	// Skip the test if the `-short` flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := LoginAttemptModel{db}
+	at := time.Date(2021, 12, 18, 10, 0, 0, 0, time.UTC)
+
+	// An unknown key has no failures.
+	failures, _, err := m.Get("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 0 {
+		t.Errorf("want %d; got %d", 0, failures)
+	}
+
+	// Every failure increments the counter and moves the time of the last one.
+	for i := 1; i <= 2; i++ {
+		failures, err = m.Fail("email:alice@example.com", at.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failures != i {
+			t.Errorf("want %d; got %d", i, failures)
+		}
+	}
+	_, last, err := m.Get("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(at.Add(2 * time.Minute)) {
+		t.Errorf("want %v; got %v", at.Add(2*time.Minute), last)
+	}
+
+	// Keys longer than the column work, and keys which only differ after
+	// the first few hundred characters have their own counters.
+	long := "email:" + strings.Repeat("a", 400)
+	_, err = m.Fail(long+"@example.com", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, _, err = m.Get(long + "@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 0 {
+		t.Errorf("want %d for another long key; got %d", 0, failures)
+	}
+
+	// Reset forgets the failures.
+	err = m.Reset("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, _, err = m.Get("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 0 {
+		t.Errorf("want %d after reset; got %d", 0, failures)
+	}
+}
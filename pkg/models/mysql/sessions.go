@@ -0,0 +1,261 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"strings"
+	"time"
+)
+
+// If the browser sends several requests at once with the same remember token, only
+// the first of them rotates it. The others present the previous validator, which
+// isn't treated as a theft for this long after the rotation.
+const rememberGracePeriod = 30 * time.Second
+
+// Define a SessionModel type which wraps a sql.DB connection pool. It keeps the
+// server-side login sessions, so that a session can be revoked from anywhere.
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// The New method starts a new session for the given user which is valid for ttl.
+// Like tokens, only the SHA-256 hash of the session token is stored, and the
+// plain-text token is returned to the caller to be put into the session cookie.
+func (m *SessionModel) New(userID int, ip, userAgent string, ttl time.Duration) (string, error) {
+	// 32 random bytes give us 256 bits of entropy.
+	plaintext, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(plaintext))
+
+	// The user agent header can be of any length, but we only need it to let the
+	// user recognise their devices.
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	// Clean up the expired sessions of the user while we are here.
+	_, err = m.DB.Exec(`DELETE FROM user_sessions WHERE user_id = ? AND expiry <= UTC_TIMESTAMP()`, userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO user_sessions (hash, user_id, ip, user_agent, created, last_seen, expiry)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = m.DB.Exec(stmt, hash[:], userID, ip, userAgent, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// The Get method returns the valid (not expired) session with the given token,
+// or models.ErrNoRecord if there is no such session.
+func (m *SessionModel) Get(plaintext string) (*models.Session, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	s := &models.Session{}
+	stmt := `SELECT id, user_id, ip, user_agent, created, last_seen FROM user_sessions
+	WHERE hash = ? AND expiry > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, hash[:]).Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// The Touch method records that the session has just been used from the given IP address.
+func (m *SessionModel) Touch(id int, ip string) error {
+	stmt := `UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, ip, id)
+	return err
+}
+
+// The List method returns the valid sessions of the given user, the most recently used first.
+func (m *SessionModel) List(userID int) ([]*models.Session, error) {
+	stmt := `SELECT id, user_id, ip, user_agent, created, last_seen FROM user_sessions
+	WHERE user_id = ? AND expiry > UTC_TIMESTAMP() ORDER BY last_seen DESC, id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		s := &models.Session{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// The Revoke method deletes the session with the given ID. The session must belong
+// to the given user, otherwise (or if there is no such session) models.ErrNoRecord is returned.
+func (m *SessionModel) Revoke(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM user_sessions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// The RevokeOthers method deletes all the sessions of the given user except the one with the ID keep.
+func (m *SessionModel) RevokeOthers(userID, keep int) error {
+	_, err := m.DB.Exec(`DELETE FROM user_sessions WHERE user_id = ? AND id <> ?`, userID, keep)
+	return err
+}
+
+// The Remember method turns the session with the given token into a "remember me"
+// session which lives for ttl, and returns a remember token for it. The remember
+// token has the form "selector:validator": the selector finds the session, the
+// validator proves that the token is genuine. Only the hash of the validator is stored.
+func (m *SessionModel) Remember(token string, ttl time.Duration) (string, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	// 12 random bytes encode to exactly 16 characters for the selector.
+	selector, err := randomToken(12)
+	if err != nil {
+		return "", err
+	}
+	validator, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	validatorHash := sha256.Sum256([]byte(validator))
+
+	stmt := `UPDATE user_sessions SET remember_selector = ?, remember_hash = ?, remember_previous_hash = NULL,
+	remember_rotated = UTC_TIMESTAMP(), expiry = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+	WHERE hash = ? AND expiry > UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, selector, validatorHash[:], int(ttl.Seconds()), hash[:])
+	if err != nil {
+		return "", err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", models.ErrNoRecord
+	}
+	return selector + ":" + validator, nil
+}
+
+// The Restore method is used when the session cookie has expired but the browser
+// still has the remember token. It checks the token, replaces both the session
+// token and the validator with new ones (so that every remember token works only
+// once), extends the session for ttl and returns the new session and remember tokens.
+//
+// If the selector is known but the validator is an old one, somebody has used the
+// token after it was rotated: either the user or the thief has a copy of it. We can't
+// tell which one, so all the sessions of the user are deleted and models.ErrTokenReuse
+// is returned. The only exception is the previous validator within rememberGracePeriod
+// after the rotation, which happens when the browser sends a few requests at once:
+// then models.ErrTokenRotated is returned, and the browser keeps the new token.
+func (m *SessionModel) Restore(rememberToken string, ttl time.Duration) (string, string, error) {
+	parts := strings.SplitN(rememberToken, ":", 2)
+	if len(parts) != 2 {
+		return "", "", models.ErrNoRecord
+	}
+	selector, validator := parts[0], parts[1]
+	validatorHash := sha256.Sum256([]byte(validator))
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var id, userID int
+	var currentHash, previousHash []byte
+	var rotated time.Time
+	stmt := `SELECT id, user_id, remember_hash, remember_previous_hash, remember_rotated FROM user_sessions
+	WHERE remember_selector = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, selector).Scan(&id, &userID, &currentHash, &previousHash, &rotated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", models.ErrNoRecord
+		} else {
+			return "", "", err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(validatorHash[:], currentHash) != 1 {
+		if previousHash != nil && subtle.ConstantTimeCompare(validatorHash[:], previousHash) == 1 &&
+			time.Since(rotated) < rememberGracePeriod {
+			return "", "", models.ErrTokenRotated
+		}
+
+		_, err = tx.Exec(`DELETE FROM user_sessions WHERE user_id = ?`, userID)
+		if err != nil {
+			return "", "", err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return "", "", err
+		}
+		return "", "", models.ErrTokenReuse
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	newValidator, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.Sum256([]byte(token))
+	newValidatorHash := sha256.Sum256([]byte(newValidator))
+
+	stmt = `UPDATE user_sessions SET hash = ?, remember_hash = ?, remember_previous_hash = ?,
+	remember_rotated = UTC_TIMESTAMP(), last_seen = UTC_TIMESTAMP(), expiry = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+	WHERE id = ?`
+	_, err = tx.Exec(stmt, hash[:], newValidatorHash[:], currentHash, int(ttl.Seconds()), id)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", "", err
+	}
+	return token, selector + ":" + newValidator, nil
+}
+
+// The randomToken function returns n random bytes encoded with URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestSessionModelRevoke(t *testing.T) {
+	// Skip the test if the `-short` flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := SessionModel{db}
+
+	laptop, err := m.New(1, "192.0.2.1", "Laptop", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, err := m.New(1, "192.0.2.2", "Phone", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := m.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("want %d sessions; got %d", 2, len(sessions))
+	}
+
+	s, err := m.Get(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody else can revoke the session...
+	err = m.Revoke(2, s.ID)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+
+	// ...but its owner can, and then the token doesn't work any more.
+	err = m.Revoke(1, s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get(phone)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+
+	// A password change revokes all the sessions of the user.
+	err = (&UserModel{db}).UpdatePassword(1, "newPa$$word123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get(laptop)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+}
+
+func TestSessionModelRestore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := SessionModel{db}
+
+	token, err := m.New(1, "192.0.2.1", "Laptop", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remember, err := m.Remember(token, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remember token gives a new session token and is rotated itself.
+	newToken, newRemember, err := m.Restore(remember, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken == token || newRemember == remember {
+		t.Errorf("want the tokens to be rotated")
+	}
+	_, err = m.Get(newToken)
+	if err != nil {
+		t.Errorf("want the new session token to work; got %v", err)
+	}
+	_, err = m.Get(token)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v for the old session token; got %v", models.ErrNoRecord, err)
+	}
+
+	// Right after the rotation the old token is answered with ErrTokenRotated
+	// (a concurrent request of the same browser), and the new session stays.
+	_, _, err = m.Restore(remember, 24*time.Hour)
+	if err != models.ErrTokenRotated {
+		t.Errorf("want %v; got %v", models.ErrTokenRotated, err)
+	}
+	_, err = m.Get(newToken)
+	if err != nil {
+		t.Errorf("want the new session token to work within the grace period; got %v", err)
+	}
+
+	// A made up validator is simply unknown.
+	_, _, err = m.Restore("nosuchselector00:validator", 24*time.Hour)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+
+	// Pretend that the grace period is over: now the reuse of the old token is
+	// treated as a theft and all the sessions of the user are deleted.
+	_, err = db.Exec(`UPDATE user_sessions SET remember_rotated = DATE_SUB(remember_rotated, INTERVAL 1 HOUR)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = m.Restore(remember, 24*time.Hour)
+	if err != models.ErrTokenReuse {
+		t.Errorf("want %v; got %v", models.ErrTokenReuse, err)
+	}
+	_, err = m.Get(newToken)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v after the theft; got %v", models.ErrNoRecord, err)
+	}
+}
@@ -11,20 +11,28 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+// This will insert a new snippet of the given user into the database. If teamID
+// isn't 0, the snippet belongs to that team, and the user must be a member of it.
+func (m *SnippetModel) Insert(userID, teamID int, title, content, expires, visibility string) (int, error) {
+	if teamID != 0 {
+		err := checkTeamMember(m.DB, teamID, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	// Write the SQL statement we want to execute. I've split it over two lines
 	// for readability (which is why it's surrounded with backquotes instead
 	// of normal double quotes).
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, team_id, visibility)
+	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, NULLIF(?, 0), ?)`
 
 	// Use the Exec() method on the embedded connection pool to execute
 	// statement. The first parameter is the SQL statement, followed by
 	// title, content and expiry values for the placeholder parameters.
 	// method returns a sql.Result object, which contains some basic
 	// information about what happened when the statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := m.DB.Exec(stmt, title, content, expires, userID, teamID, visibility)
 	if err != nil {
 		return 0, err
 	}
@@ -48,7 +56,7 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 
 	// Write the SQL statement we want to execute. Again, I've split it over two
 	// lines for readability.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), COALESCE(team_id, 0), visibility FROM snippets
 	WHERE expires > UTC_TIMESTAMP() AND id = ?`
 
 	// Use the QueryRow() method on the connection pool to execute our
@@ -60,7 +68,7 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	// to row.Scan are *pointers* to the place you want to copy the data into,
 	// and the number of arguments must be exactly the same as the number of
 	// columns returned by your statement.
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.TeamID, &s.Visibility)
 
 	if err != nil {
 		// If the query returns no rows, then row.Scan() will return a
@@ -82,11 +90,11 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	return s, nil
 }
 
-// This will return the 10 most recently created snippets.
+// This will return the 10 most recently created public snippets.
 func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	// Write the SQL statement we want to execute.
-	stmt := `SELECT id, title, content, created, expires 
-			FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), COALESCE(team_id, 0), visibility
+			FROM snippets WHERE expires > UTC_TIMESTAMP() AND visibility = 'public' ORDER BY created DESC LIMIT 10`
 
 	// Use the Query() method on the connection pool to execute our
 	// SQL statement. This returns a sql.Rows resultset containing the result of
@@ -117,7 +125,7 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 
 		// Use rows.Scan() to copy the values from each field in the row to the // new Snippet object that we created. Again, the arguments to row.Scan() // must be pointers to the place you want to copy the data into, and the // number of arguments must be exactly the same as the number of
 		// columns returned by your statement.
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.TeamID, &s.Visibility)
 		if err != nil {
 			return nil, err }
 
@@ -136,3 +144,92 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	// If everything went OK then return the Snippets slice.
 	return snippets, nil
 }
+
+// The Recent method returns the most recently created snippets, including
+// the expired ones, at most limit of them. It is used by the admin area.
+func (m *SnippetModel) Recent(limit int) ([]*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), COALESCE(team_id, 0), visibility
+	FROM snippets ORDER BY created DESC, id DESC LIMIT ?`
+
+	return m.query(stmt, limit)
+}
+
+// The PublicByUser method returns the public snippets of the given user which
+// haven't expired yet, the newest first. Unlisted and private snippets are never
+// returned. The limit and offset parameters select a page of the list.
+func (m *SnippetModel) PublicByUser(userID, limit, offset int) ([]*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), COALESCE(team_id, 0), visibility
+	FROM snippets WHERE user_id = ? AND visibility = 'public' AND expires > UTC_TIMESTAMP()
+	ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	return m.query(stmt, userID, limit, offset)
+}
+
+// The ByTeam method returns the snippets of the given team which haven't expired
+// yet, the newest first, if the user is a member of the team. Otherwise it returns
+// models.ErrNotTeamMember. The limit and offset parameters select a page of the list.
+func (m *SnippetModel) ByTeam(userID, teamID, limit, offset int) ([]*models.Snippet, error) {
+	err := checkTeamMember(m.DB, teamID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), COALESCE(team_id, 0), visibility
+	FROM snippets WHERE team_id = ? AND expires > UTC_TIMESTAMP()
+	ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	return m.query(stmt, teamID, limit, offset)
+}
+
+// The query helper runs a SELECT statement which returns snippets and scans them.
+func (m *SnippetModel) query(stmt string, args ...interface{}) ([]*models.Snippet, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := &models.Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.TeamID, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// The Count method returns the number of all snippets and the number of
+// the ones which haven't expired yet.
+func (m *SnippetModel) Count() (int, int, error) {
+	var total, live int
+	stmt := `SELECT COUNT(*), COALESCE(SUM(expires > UTC_TIMESTAMP()), 0) FROM snippets`
+	err := m.DB.QueryRow(stmt).Scan(&total, &live)
+	if err != nil {
+		return 0, 0, err
+	}
+	return total, live, nil
+}
+
+// The Delete method deletes the given snippet. It returns models.ErrNoRecord
+// if there is no such snippet.
+func (m *SnippetModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
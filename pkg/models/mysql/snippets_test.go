@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"testing"
+)
+
+func TestSnippetModelPublicByUser(t *testing.T) {
+	// Skip the test if the `-short` flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := SnippetModel{db}
+
+	// Alice has snippets with every visibility, and one which has already expired.
+	var public []int
+	for _, visibility := range []string{models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate, models.VisibilityPublic} {
+		id, err := m.Insert(1, 0, "Title", "Content", "7", visibility)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visibility == models.VisibilityPublic {
+			public = append(public, id)
+		}
+	}
+	expired, err := m.Insert(1, 0, "Title", "Content", "-1", models.VisibilityPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  int
+		limit   int
+		offset  int
+		wantIDs []int
+	}{
+		{"All public snippets, newest first", 1, 10, 0, []int{public[1], public[0]}},
+		{"First page", 1, 1, 0, []int{public[1]}},
+		{"Second page", 1, 1, 1, []int{public[0]}},
+		{"Past the end", 1, 10, 2, nil},
+		{"Another user", 2, 10, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets, err := m.PublicByUser(tt.userID, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, s := range snippets {
+				if s.ID == expired {
+					t.Errorf("want the expired snippet to be skipped")
+				}
+				if s.Visibility != models.VisibilityPublic {
+					t.Errorf("want only public snippets; got %q", s.Visibility)
+				}
+				ids = append(ids, s.ID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("want %v; got %v", tt.wantIDs, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("want %v; got %v", tt.wantIDs, ids)
+				}
+			}
+		})
+	}
+}
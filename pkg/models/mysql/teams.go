@@ -0,0 +1,328 @@
+package mysql
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"strings"
+	"time"
+)
+
+// Define a TeamModel type which wraps a sql.DB connection pool. Every method
+// which acts on behalf of a user checks that the user is allowed to do it, so
+// that the handlers can't forget about the membership checks.
+type TeamModel struct {
+	DB *sql.DB
+}
+
+// The queryRower interface is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// The teamRole function returns the role of the user in the team, or
+// models.ErrNotTeamMember if the user isn't a member of the team.
+func teamRole(db queryRower, teamID, userID int) (string, error) {
+	var role string
+	stmt := `SELECT role FROM team_members WHERE team_id = ? AND user_id = ?`
+	err := db.QueryRow(stmt, teamID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrNotTeamMember
+		} else {
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+// The checkTeamMember function returns models.ErrNotTeamMember if the user isn't a member of the team.
+func checkTeamMember(db queryRower, teamID, userID int) error {
+	_, err := teamRole(db, teamID, userID)
+	return err
+}
+
+// The checkTeamOwner function returns models.ErrNotTeamOwner if the user isn't
+// an owner of the team, or models.ErrNotTeamMember if they aren't in the team at all.
+func checkTeamOwner(db queryRower, teamID, userID int) error {
+	role, err := teamRole(db, teamID, userID)
+	if err != nil {
+		return err
+	}
+	if role != models.TeamRoleOwner {
+		return models.ErrNotTeamOwner
+	}
+	return nil
+}
+
+// The Create method creates a new team with the given user as its owner.
+func (m *TeamModel) Create(userID int, name string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO teams (name, created) VALUES(?, UTC_TIMESTAMP())`, name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO team_members (team_id, user_id, role, joined) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.Exec(stmt, id, userID, models.TeamRoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// The Get method returns the team with the given ID together with the role of
+// the user in it. If the user isn't a member of the team, it returns models.ErrNotTeamMember,
+// so that the details of the team are never shown to outsiders.
+func (m *TeamModel) Get(userID, teamID int) (*models.Team, error) {
+	t := &models.Team{}
+	stmt := `SELECT t.id, t.name, t.created, m.role FROM teams t
+	JOIN team_members m ON m.team_id = t.id AND m.user_id = ?
+	WHERE t.id = ?`
+	err := m.DB.QueryRow(stmt, userID, teamID).Scan(&t.ID, &t.Name, &t.Created, &t.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotTeamMember
+		} else {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// The ForUser method returns the teams of the given user, sorted by name.
+func (m *TeamModel) ForUser(userID int) ([]*models.Team, error) {
+	stmt := `SELECT t.id, t.name, t.created, m.role FROM teams t
+	JOIN team_members m ON m.team_id = t.id
+	WHERE m.user_id = ? ORDER BY t.name, t.id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []*models.Team{}
+	for rows.Next() {
+		t := &models.Team{}
+		err = rows.Scan(&t.ID, &t.Name, &t.Created, &t.Role)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// The IsMember method reports whether the user is a member of the team.
+func (m *TeamModel) IsMember(teamID, userID int) (bool, error) {
+	err := checkTeamMember(m.DB, teamID, userID)
+	if errors.Is(err, models.ErrNotTeamMember) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// The Members method returns the members of the team, owners first. Only the
+// members of the team can see the list, for anybody else it returns models.ErrNotTeamMember.
+func (m *TeamModel) Members(userID, teamID int) ([]*models.TeamMember, error) {
+	err := checkTeamMember(m.DB, teamID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `SELECT u.id, u.name, m.role, m.joined FROM team_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.team_id = ? ORDER BY m.role = 'owner' DESC, u.name, u.id`
+
+	rows, err := m.DB.Query(stmt, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*models.TeamMember{}
+	for rows.Next() {
+		tm := &models.TeamMember{}
+		err = rows.Scan(&tm.UserID, &tm.Name, &tm.Role, &tm.Joined)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, tm)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// The Invite method creates an invitation to join the team for the given email
+// address, which is valid for ttl. Only owners can invite. Like other tokens,
+// only the hash of the invitation token is stored, the plain-text token is
+// returned to be sent to the invitee.
+func (m *TeamModel) Invite(userID, teamID int, email string, ttl time.Duration) (string, error) {
+	err := checkTeamOwner(m.DB, teamID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `INSERT INTO team_invitations (hash, team_id, email, invited_by, expiry)
+	VALUES(?, ?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.Exec(stmt, hash[:], teamID, email, userID, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// The Invitation method returns the details of a valid (not expired) invitation,
+// or models.ErrNoRecord if there is no such invitation.
+func (m *TeamModel) Invitation(token string) (*models.TeamInvitation, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	inv := &models.TeamInvitation{}
+	stmt := `SELECT i.team_id, t.name, i.email FROM team_invitations i
+	JOIN teams t ON t.id = i.team_id
+	WHERE i.hash = ? AND i.expiry > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, hash[:]).Scan(&inv.TeamID, &inv.TeamName, &inv.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// The Accept method adds the user to the team of the invitation and deletes the
+// invitation. The invitation can only be accepted by the user with the email
+// address it was sent to, otherwise (or if it is invalid) models.ErrNoRecord is
+// returned. It returns the ID of the team.
+func (m *TeamModel) Accept(token string, userID int, email string) (int, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var teamID int
+	var invitedEmail string
+	stmt := `SELECT team_id, email FROM team_invitations
+	WHERE hash = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hash[:]).Scan(&teamID, &invitedEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+	if !strings.EqualFold(invitedEmail, email) {
+		return 0, models.ErrNoRecord
+	}
+
+	_, err = tx.Exec(`DELETE FROM team_invitations WHERE hash = ?`, hash[:])
+	if err != nil {
+		return 0, err
+	}
+
+	// Accepting an invitation to a team the user is already in changes nothing.
+	stmt = `INSERT IGNORE INTO team_members (team_id, user_id, role, joined) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.Exec(stmt, teamID, userID, models.TeamRoleMember)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return teamID, nil
+}
+
+// The Leave method removes the user from the team. The last owner can't leave
+// the team (models.ErrLastTeamOwner), otherwise the team would be left without
+// anybody to manage it.
+func (m *TeamModel) Leave(userID, teamID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the owners of the team, so that two owners can't leave at the same time.
+	var owners int
+	stmt := `SELECT COUNT(*) FROM team_members WHERE team_id = ? AND role = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, teamID, models.TeamRoleOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+
+	role, err := teamRole(tx, teamID, userID)
+	if err != nil {
+		return err
+	}
+	if role == models.TeamRoleOwner && owners == 1 {
+		return models.ErrLastTeamOwner
+	}
+
+	_, err = tx.Exec(`DELETE FROM team_members WHERE team_id = ? AND user_id = ?`, teamID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The RemoveMember method removes a member from the team. Only owners can remove
+// members, and they can't remove other owners. It returns models.ErrNoRecord if
+// there is no such member in the team.
+func (m *TeamModel) RemoveMember(userID, teamID, memberID int) error {
+	err := checkTeamOwner(m.DB, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `DELETE FROM team_members WHERE team_id = ? AND user_id = ? AND role = ?`
+	result, err := m.DB.Exec(stmt, teamID, memberID, models.TeamRoleMember)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
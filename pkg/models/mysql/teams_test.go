@@ -0,0 +1,116 @@
+package mysql
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestTeamModel(t *testing.T) {
+	// Skip the test if the `-short` flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	// Bob is the second user, he isn't in the team at first.
+	err := (&UserModel{db}).Insert("Bob", "bob@example.com", "validPa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := TeamModel{db}
+	snippets := SnippetModel{db}
+
+	teamID, err := m.Create(1, "Core")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob can't see the team, its members or its snippets, and can't post to it.
+	_, err = m.Get(2, teamID)
+	if err != models.ErrNotTeamMember {
+		t.Errorf("want %v; got %v", models.ErrNotTeamMember, err)
+	}
+	_, err = m.Members(2, teamID)
+	if err != models.ErrNotTeamMember {
+		t.Errorf("want %v; got %v", models.ErrNotTeamMember, err)
+	}
+	_, err = snippets.ByTeam(2, teamID, 10, 0)
+	if err != models.ErrNotTeamMember {
+		t.Errorf("want %v; got %v", models.ErrNotTeamMember, err)
+	}
+	_, err = snippets.Insert(2, teamID, "Title", "Content", "7", models.VisibilityTeam)
+	if err != models.ErrNotTeamMember {
+		t.Errorf("want %v; got %v", models.ErrNotTeamMember, err)
+	}
+	_, err = m.Invite(2, teamID, "bob@example.com", time.Hour)
+	if err != models.ErrNotTeamMember {
+		t.Errorf("want %v; got %v", models.ErrNotTeamMember, err)
+	}
+
+	// Alice invites Bob. The invitation can't be accepted by anybody else.
+	token, err := m.Invite(1, teamID, "bob@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Accept(token, 1, "alice@example.com")
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	id, err := m.Accept(token, 2, "Bob@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != teamID {
+		t.Errorf("want %d; got %d", teamID, id)
+	}
+
+	// The invitation can be used only once.
+	_, err = m.Accept(token, 2, "bob@example.com")
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+
+	// Now Bob is a member: he can post to the team, but can't invite others.
+	snippetID, err := snippets.Insert(2, teamID, "Title", "Content", "7", models.VisibilityTeam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team, err := snippets.ByTeam(1, teamID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(team) != 1 || team[0].ID != snippetID {
+		t.Errorf("want the snippet %d; got %v", snippetID, team)
+	}
+	_, err = m.Invite(2, teamID, "carol@example.com", time.Hour)
+	if err != models.ErrNotTeamOwner {
+		t.Errorf("want %v; got %v", models.ErrNotTeamOwner, err)
+	}
+	err = m.RemoveMember(2, teamID, 1)
+	if err != models.ErrNotTeamOwner {
+		t.Errorf("want %v; got %v", models.ErrNotTeamOwner, err)
+	}
+
+	// The only owner can't leave the team.
+	err = m.Leave(1, teamID)
+	if err != models.ErrLastTeamOwner {
+		t.Errorf("want %v; got %v", models.ErrLastTeamOwner, err)
+	}
+
+	// Alice removes Bob, after that he isn't a member any more.
+	err = m.RemoveMember(1, teamID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := m.IsMember(teamID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("want Bob to be removed from the team")
+	}
+}
@@ -1,13 +1,18 @@
 CREATE TABLE snippets
 (
-    id      INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    title   VARCHAR(100) NOT NULL,
-    content TEXT         NOT NULL,
-    created DATETIME     NOT NULL,
-    expires DATETIME     NOT NULL
+    id         INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    title      VARCHAR(100) NOT NULL,
+    content    TEXT         NOT NULL,
+    created    DATETIME     NOT NULL,
+    expires    DATETIME     NOT NULL,
+    user_id    INTEGER      NULL,
+    team_id    INTEGER      NULL,
+    visibility VARCHAR(16)  NOT NULL DEFAULT 'public'
 );
 
 CREATE INDEX idx_snippets_created ON snippets (created);
+CREATE INDEX idx_snippets_user_created ON snippets (user_id, created);
+CREATE INDEX idx_snippets_team_created ON snippets (team_id, created);
 
 CREATE TABLE users
 (
@@ -16,12 +21,127 @@ CREATE TABLE users
     email           VARCHAR(255) NOT NULL,
     hashed_password CHAR(60)     NOT NULL,
     created         DATETIME     NOT NULL,
-    active          BOOLEAN      NOT NULL DEFAULT TRUE
+    active          BOOLEAN      NOT NULL DEFAULT TRUE,
+    totp_secret     VARCHAR(32)  NOT NULL DEFAULT '',
+    totp_last_step  BIGINT       NOT NULL DEFAULT 0,
+    role            VARCHAR(16)  NOT NULL DEFAULT 'user'
 );
 
 ALTER TABLE users
     ADD CONSTRAINT users_uc_email UNIQUE (email);
 
+ALTER TABLE snippets
+    ADD CONSTRAINT snippets_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE TABLE teams
+(
+    id      INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name    VARCHAR(100) NOT NULL,
+    created DATETIME     NOT NULL
+);
+
+CREATE TABLE team_members
+(
+    team_id INTEGER     NOT NULL,
+    user_id INTEGER     NOT NULL,
+    role    VARCHAR(16) NOT NULL,
+    joined  DATETIME    NOT NULL,
+    PRIMARY KEY (team_id, user_id),
+    CONSTRAINT team_members_fk_team FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE,
+    CONSTRAINT team_members_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_team_members_user ON team_members (user_id);
+
+CREATE TABLE team_invitations
+(
+    hash       BINARY(32)   NOT NULL PRIMARY KEY,
+    team_id    INTEGER      NOT NULL,
+    email      VARCHAR(255) NOT NULL,
+    invited_by INTEGER      NOT NULL,
+    expiry     DATETIME     NOT NULL,
+    CONSTRAINT team_invitations_fk_team FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE,
+    CONSTRAINT team_invitations_fk_user FOREIGN KEY (invited_by) REFERENCES users (id) ON DELETE CASCADE
+);
+
+ALTER TABLE snippets
+    ADD CONSTRAINT snippets_fk_team FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE;
+
+CREATE TABLE tokens
+(
+    hash    BINARY(32)   NOT NULL PRIMARY KEY,
+    user_id INTEGER      NOT NULL,
+    expiry  DATETIME     NOT NULL,
+    scope   VARCHAR(32)  NOT NULL,
+    data    VARCHAR(255) NOT NULL DEFAULT '',
+    CONSTRAINT tokens_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes
+(
+    user_id INTEGER    NOT NULL,
+    hash    BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, hash),
+    CONSTRAINT recovery_codes_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_identities
+(
+    issuer  VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INTEGER      NOT NULL,
+    created DATETIME     NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT user_identities_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_sessions
+(
+    id                     INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    hash                   BINARY(32)   NOT NULL,
+    user_id                INTEGER      NOT NULL,
+    ip                     VARCHAR(45)  NOT NULL,
+    user_agent             VARCHAR(255) NOT NULL,
+    created                DATETIME     NOT NULL,
+    last_seen              DATETIME     NOT NULL,
+    expiry                 DATETIME     NOT NULL,
+    remember_selector      CHAR(16)     NULL,
+    remember_hash          BINARY(32)   NULL,
+    remember_previous_hash BINARY(32)   NULL,
+    remember_rotated       DATETIME     NULL,
+    CONSTRAINT user_sessions_uc_hash UNIQUE (hash),
+    CONSTRAINT user_sessions_uc_remember_selector UNIQUE (remember_selector),
+    CONSTRAINT user_sessions_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE login_attempts
+(
+    attempt_key  CHAR(64)     NOT NULL PRIMARY KEY,
+    failures     INTEGER      NOT NULL,
+    last_failure DATETIME(6)  NOT NULL
+);
+
+CREATE TABLE audit_events
+(
+    id         BIGINT       NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    created    DATETIME     NOT NULL,
+    actor_id   INTEGER      NULL,
+    action     VARCHAR(64)  NOT NULL,
+    ip         VARCHAR(45)  NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    details    JSON         NOT NULL
+);
+
+CREATE INDEX idx_audit_events_actor ON audit_events (actor_id, id);
+
+-- The audit log is append-only: the events can't be changed or deleted, not
+-- even by the application itself.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
 INSERT INTO users (name, email, hashed_password, created) VALUES
 ('Alice Jones', 'alice@example.com', '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG', '2018-12-23 17:25:22');
 
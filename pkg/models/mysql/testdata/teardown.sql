@@ -1,4 +1,22 @@
-DROP TABLE users;
+DROP TABLE audit_events;
+
+DROP TABLE user_sessions;
+
+DROP TABLE user_identities;
+
+DROP TABLE login_attempts;
+
+DROP TABLE recovery_codes;
+
+DROP TABLE tokens;
 
 DROP TABLE snippets;
 
+DROP TABLE team_invitations;
+
+DROP TABLE team_members;
+
+DROP TABLE teams;
+
+DROP TABLE users;
+
@@ -0,0 +1,80 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/Dimau/snippetbox/pkg/models"
+	"time"
+)
+
+// Define a TokenModel type which wraps a sql.DB connection pool. It keeps the
+// single-use tokens which we send to users by email (for example, to reset a password).
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// The New method generates a new random token for the given user and scope and
+// stores its SHA-256 hash in the database together with the optional data. Only
+// the hash is stored, so a leaked database dump can't be used to reset anybody's
+// password. The plain-text token is returned to the caller so that it can be sent to the user.
+func (m *TokenModel) New(userID int, ttl time.Duration, scope, data string) (string, error) {
+	// 16 random bytes give us 128 bits of entropy. We encode them with base32
+	// without padding, so the token is safe to put into a URL as is.
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope, data)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), ?, ?)`
+
+	_, err = m.DB.Exec(stmt, hash[:], userID, int(ttl.Seconds()), scope, data)
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// The Consume method looks up a valid (not expired) token with the given scope
+// and deletes it, so that it can't be used twice. It returns the details of
+// the token, or models.ErrNoRecord if there is no such token.
+func (m *TokenModel) Consume(plaintext, scope string) (*models.Token, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t := &models.Token{}
+	stmt := `SELECT user_id, scope, data FROM tokens
+	WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hash[:], scope).Scan(&t.UserID, &t.Scope, &t.Data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	// Each token is single-use, so we delete it (and any other outstanding
+	// tokens of the same scope issued for this user) in the same transaction.
+	_, err = tx.Exec(`DELETE FROM tokens WHERE user_id = ? AND scope = ?`, t.UserID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,45 @@
+package mysql
+
+import (
+	"github.com/Dimau/snippetbox/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestTokenModelConsume(t *testing.T) {
+	// Skip the test if the `-short` flag is provided when running the test.
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := TokenModel{db}
+
+	token, err := m.New(1, time.Hour, models.ScopePasswordReset, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token can't be used with another scope.
+	_, err = m.Consume(token, "another-scope")
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+
+	// The first use of the token returns the ID of its owner...
+	tok, err := m.Consume(token, models.ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.UserID != 1 {
+		t.Errorf("want %d; got %d", 1, tok.UserID)
+	}
+
+	// ...and the second one fails, because the token is single-use.
+	_, err = m.Consume(token, models.ScopePasswordReset)
+	if err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+}
@@ -1,12 +1,16 @@
 package mysql
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/Dimau/snippetbox/pkg/models"
+	"github.com/Dimau/snippetbox/pkg/totp"
 	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
 	"strings"
+	"time"
 )
 
 type UserModel struct {
@@ -16,8 +20,23 @@ type UserModel struct {
 // We'll use the Get method to fetch details for a specific user based on their user ID.
 func (m *UserModel) Get(id int) (*models.User, error) {
 	u := &models.User{}
-	stmt := `SELECT id, name, email, created, active FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active)
+	stmt := `SELECT id, name, email, created, active, totp_secret <> '', role FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.TwoFactorEnabled, &u.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// The GetByEmail method fetches details for a specific user based on their email address.
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	u := &models.User{}
+	stmt := `SELECT id, name, email, created, active, totp_secret <> '', role FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.TwoFactorEnabled, &u.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -41,21 +60,97 @@ func (m *UserModel) Insert(name, email, password string) error {
 	// Use the Exec() method to insert the user details and hashed password into the users table.
 	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
-		// If this returns an error, we use the errors.As() function to check
-		// whether the error has the type *mysql.MySQLError. If it does, the
-		// error will be assigned to the mySQLError variable. We can then check
-		// whether or not the error relates to our users_uc_email key by
-		// checking the contents of the message string. If it does, we return
-		// an ErrDuplicateEmail error.
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return models.ErrDuplicateEmail
-			}
+		return duplicateEmailError(err)
+	}
+	return nil
+}
+
+// The duplicateEmailError function translates MySQL errors about the
+// users_uc_email key into models.ErrDuplicateEmail.
+func duplicateEmailError(err error) error {
+	// We use the errors.As() function to check whether the error has the type
+	// *mysql.MySQLError. If it does, the error will be assigned to the mySQLError
+	// variable. We can then check whether or not the error relates to our
+	// users_uc_email key by checking the contents of the message string. If it
+	// does, we return an ErrDuplicateEmail error.
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+			return models.ErrDuplicateEmail
 		}
+	}
+	return err
+}
+
+// The List method returns the most recently registered users, at most limit of them.
+func (m *UserModel) List(limit int) ([]*models.User, error) {
+	stmt := `SELECT id, name, email, created, active, totp_secret <> '', role
+	FROM users ORDER BY created DESC, id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u := &models.User{}
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.TwoFactorEnabled, &u.Role)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// The Count method returns the number of all users and the number of active ones.
+func (m *UserModel) Count() (int, int, error) {
+	var total, active int
+	stmt := `SELECT COUNT(*), COALESCE(SUM(active), 0) FROM users`
+	err := m.DB.QueryRow(stmt).Scan(&total, &active)
+	if err != nil {
+		return 0, 0, err
+	}
+	return total, active, nil
+}
+
+// The SetActive method activates or deactivates the given user. A deactivated
+// user can't log in, and all their sessions are deleted.
+func (m *UserModel) SetActive(id int, active bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET active = ? WHERE id = ?`, active, id)
+	if err != nil {
+		return err
+	}
+
+	if !active {
+		_, err = tx.Exec(`DELETE FROM user_sessions WHERE user_id = ?`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The SetRole method changes the role of the given user.
+func (m *UserModel) SetRole(id int, role string) error {
+	if !models.ValidRole(role) {
+		return fmt.Errorf("models: unknown role %q", role)
+	}
+	stmt := `UPDATE users SET role = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, role, id)
+	return err
 }
 
 // We'll use the Authenticate method to verify whether a user exists with
@@ -91,3 +186,173 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	return id, nil
 }
 
+// The UpdatePassword method replaces the password of the given user. It also deletes
+// all the sessions of the user, so that everybody who has logged in with the old
+// password (for example, with a stolen one) is logged out.
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET hashed_password = ? WHERE id = ?`
+	result, err := tx.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrNoRecord
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_sessions WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The UpdateName method changes the name of the given user.
+func (m *UserModel) UpdateName(id int, name string) error {
+	stmt := `UPDATE users SET name = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, name, id)
+	return err
+}
+
+// The UpdateEmail method changes the email address of the given user. If the
+// address is already used by another account, it returns models.ErrDuplicateEmail.
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	stmt := `UPDATE users SET email = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, email, id)
+	if err != nil {
+		return duplicateEmailError(err)
+	}
+	return nil
+}
+
+// The EnableTOTP method turns on two-factor authentication for the given user.
+// It stores the TOTP secret and replaces the recovery codes of the user. Like
+// tokens, the recovery codes are stored as SHA-256 hashes only.
+func (m *UserModel) EnableTOTP(id int, secret string, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?`, secret, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		hash := sha256.Sum256([]byte(code))
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)`, id, hash[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The DisableTOTP method turns off two-factor authentication for the given user
+// and deletes the remaining recovery codes.
+func (m *UserModel) DisableTOTP(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = '', totp_last_step = 0 WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The AuthenticateTOTP method checks the TOTP code entered by the user at the second
+// step of the login. If the code is wrong, or it has already been used, it returns
+// models.ErrInvalidCredentials.
+func (m *UserModel) AuthenticateTOTP(id int, code string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row, so that two concurrent requests can't both use the same code.
+	var secret string
+	var lastStep int64
+	stmt := `SELECT totp_secret, totp_last_step FROM users WHERE id = ? AND active = TRUE FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&secret, &lastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrInvalidCredentials
+		} else {
+			return err
+		}
+	}
+	if secret == "" {
+		return models.ErrInvalidCredentials
+	}
+
+	// A code is valid for a few time steps. Remember the step of the last accepted
+	// code and refuse the codes for the same or earlier steps, so that a code
+	// which has been seen by somebody else can't be replayed.
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return models.ErrInvalidCredentials
+	}
+
+	_, err = tx.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ?`, step, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The AuthenticateRecoveryCode method checks a recovery code entered by the user
+// instead of a TOTP code. Each recovery code can be used only once, so it is deleted
+// on success. If there is no such code, it returns models.ErrInvalidCredentials.
+func (m *UserModel) AuthenticateRecoveryCode(id int, code string) error {
+	hash := sha256.Sum256([]byte(totp.NormalizeRecoveryCode(code)))
+
+	result, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, id, hash[:])
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrInvalidCredentials
+	}
+	return nil
+}
@@ -29,6 +29,7 @@ func TestUserModelGet(t *testing.T) {
 				Email:   "alice@example.com",
 				Created: time.Date(2018, 12, 23, 17, 25, 22, 0, time.UTC),
 				Active:  true,
+				Role:    models.RoleUser,
 			},
 			wantError: nil,
 		},
@@ -68,3 +69,39 @@ func TestUserModelGet(t *testing.T) {
 		})
 	}
 }
+
+func TestUserModelSetRole(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := UserModel{db}
+
+	err := m.SetRole(1, models.RoleModerator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetActive(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := m.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleModerator || user.Active {
+		t.Errorf("want role %q and inactive; got %q and active %v", models.RoleModerator, user.Role, user.Active)
+	}
+	if !user.HasRole(models.RoleUser) || user.HasRole(models.RoleAdmin) {
+		t.Errorf("want a moderator to have the user role but not the admin one")
+	}
+
+	err = m.SetRole(1, "root")
+	if err == nil {
+		t.Errorf("want an error for an unknown role; got nil")
+	}
+}
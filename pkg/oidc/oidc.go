@@ -0,0 +1,213 @@
+// Package oidc implements the parts of OpenID Connect which we need to log users
+// in with an external identity provider: discovery, the authorization code flow
+// with PKCE and verification of ID tokens against the JWKS of the provider.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned when an ID token fails verification.
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// Config holds the settings of one identity provider.
+type Config struct {
+	// Name identifies the provider in URLs, for example "corp".
+	Name string `json:"name"`
+	// DisplayName is shown to users on the login page.
+	DisplayName string `json:"display_name"`
+	// Issuer is the issuer identifier of the provider. The discovery document is
+	// fetched from Issuer + "/.well-known/openid-configuration".
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Claims holds the claims of a verified ID token which we are interested in.
+type Claims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Provider talks to one identity provider. The discovery document and the
+// keys of the provider are fetched lazily and cached, so the application can
+// start even if the provider is temporarily unavailable.
+type Provider struct {
+	Config
+	client *http.Client
+	// Now returns the current time. It can be replaced in tests.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	endpoints   *endpoints
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+type endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider returns a Provider for the given configuration. If client is nil,
+// a client with a 10 second timeout is used.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Provider{Config: cfg, client: client, Now: time.Now}
+}
+
+// RandomString returns a random URL-safe string with 256 bits of entropy. It is
+// used for the state, the nonce and the PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for the code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint to redirect the user to.
+func (p *Provider) AuthCodeURL(redirectURI, state, nonce, verifier string) (string, error) {
+	e, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(e.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return e.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens at the token endpoint and
+// verifies the ID token. nonce must be the value passed to AuthCodeURL.
+func (p *Provider) Exchange(code, redirectURI, verifier, nonce string) (*Claims, error) {
+	e, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("code_verifier", verifier)
+	v.Set("client_id", p.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, e.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = p.do(req, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+
+	return p.Verify(tokens.IDToken, nonce)
+}
+
+// discover fetches the discovery document of the provider once and caches it.
+func (p *Provider) discover() (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &endpoints{}
+	err = p.do(req, e)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer in the document must be exactly the one we have been configured with
+	// (OpenID Connect Discovery, section 4.3), otherwise tokens could be accepted
+	// from somebody impersonating the provider.
+	if strings.TrimSuffix(e.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q in discovery document doesn't match %q", e.Issuer, p.Issuer)
+	}
+	if e.AuthorizationEndpoint == "" || e.TokenEndpoint == "" || e.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is incomplete")
+	}
+
+	p.endpoints = e
+	return e, nil
+}
+
+// do sends the request and decodes the JSON response into dst.
+func (p *Provider) do(req *http.Request, dst interface{}) error {
+	rs, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(rs.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s %s: %s: %s", req.Method, req.URL, rs.Status, body)
+	}
+	return json.Unmarshal(body, dst)
+}
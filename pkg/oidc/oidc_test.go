@@ -0,0 +1,178 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/Dimau/snippetbox/pkg/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const redirectURI = "https://snippetbox.test/user/login/oidc/test/callback"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	idp := oidctest.NewServer("snippetbox", "s3cret")
+	p := NewProvider(Config{
+		Name:         "test",
+		Issuer:       idp.Issuer(),
+		ClientID:     "snippetbox",
+		ClientSecret: "s3cret",
+	}, idp.Client())
+	return p, idp
+}
+
+// authorize follows the redirect to the stand-in provider and returns the
+// parameters it sends back to the callback.
+func authorize(t *testing.T, p *Provider, idp *oidctest.Server, state, nonce, verifier string) url.Values {
+	authURL, err := p.AuthCodeURL(redirectURI, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := idp.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	rs, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusFound {
+		t.Fatalf("want %d; got %d", http.StatusFound, rs.StatusCode)
+	}
+
+	location, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query()
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p, idp := newTestProvider(t)
+	defer idp.Close()
+
+	params := authorize(t, p, idp, "state1", "nonce1", "verifier-verifier-verifier-verifier-verifier")
+	if params.Get("state") != "state1" {
+		t.Errorf("want state %q; got %q", "state1", params.Get("state"))
+	}
+
+	claims, err := p.Exchange(params.Get("code"), redirectURI, "verifier-verifier-verifier-verifier-verifier", "nonce1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{
+		Issuer:        idp.Issuer(),
+		Subject:       idp.Subject,
+		Email:         idp.Email,
+		EmailVerified: true,
+		Name:          idp.Name,
+	}
+	if *claims != want {
+		t.Errorf("want %+v; got %+v", want, *claims)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	p, idp := newTestProvider(t)
+	defer idp.Close()
+
+	// Somebody who has intercepted the code can't use it without the verifier.
+	params := authorize(t, p, idp, "state1", "nonce1", "verifier-verifier-verifier-verifier-verifier")
+	_, err := p.Exchange(params.Get("code"), redirectURI, "another-verifier-another-verifier-another", "nonce1")
+	if err == nil {
+		t.Errorf("want an error; got nil")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, idp := newTestProvider(t)
+	defer idp.Close()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"Valid", idp.IDToken("nonce1", nil), nil},
+		{"Wrong nonce", idp.IDToken("nonce2", nil), ErrInvalidToken},
+		{"Wrong audience", idp.IDToken("nonce1", map[string]interface{}{"aud": "someone-else"}), ErrInvalidToken},
+		{"Multiple audiences", idp.IDToken("nonce1", map[string]interface{}{"aud": []string{"someone-else", "snippetbox"}, "azp": "snippetbox"}), nil},
+		{"Multiple audiences without azp", idp.IDToken("nonce1", map[string]interface{}{"aud": []string{"someone-else", "snippetbox"}}), ErrInvalidToken},
+		{"Wrong issuer", idp.IDToken("nonce1", map[string]interface{}{"iss": "https://evil.example.com"}), ErrInvalidToken},
+		{"Expired", idp.IDToken("nonce1", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), ErrInvalidToken},
+		{"Issued in the future", idp.IDToken("nonce1", map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}), ErrInvalidToken},
+		{"No subject", idp.IDToken("nonce1", map[string]interface{}{"sub": nil}), ErrInvalidToken},
+		{"Signed with another key", oidctest.Sign(otherKey, idp.KeyID, map[string]interface{}{
+			"iss": idp.Issuer(), "sub": "1", "aud": "snippetbox", "nonce": "nonce1",
+			"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+		}), ErrInvalidToken},
+		{"Unknown key", oidctest.Sign(idp.Key, "unknown", map[string]interface{}{
+			"iss": idp.Issuer(), "sub": "1", "aud": "snippetbox", "nonce": "nonce1",
+			"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+		}), ErrInvalidToken},
+		{"Malformed", "not-a-jwt", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Verify(tt.token, "nonce1")
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("want nil; got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("want %v; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	p, idp := newTestProvider(t)
+	defer idp.Close()
+
+	_, err := p.Verify(idp.IDToken("nonce1", nil), "nonce1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates its key. The new key is picked up from the JWKS,
+	// but not more often than once a minute.
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.Key, idp.KeyID = newKey, "new-key"
+
+	_, err = p.Verify(idp.IDToken("nonce1", nil), "nonce1")
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("want %v; got %v", ErrInvalidToken, err)
+	}
+
+	now := time.Now().Add(2 * time.Minute)
+	p.Now = func() time.Time { return now }
+	_, err = p.Verify(idp.IDToken("nonce1", map[string]interface{}{"iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}), "nonce1")
+	if err != nil {
+		t.Errorf("want nil; got %v", err)
+	}
+}
+
+func TestDiscoveryWrongIssuer(t *testing.T) {
+	idp := oidctest.NewServer("snippetbox", "s3cret")
+	defer idp.Close()
+
+	p := NewProvider(Config{Issuer: idp.URL + "/other", ClientID: "snippetbox"}, idp.Client())
+	_, err := p.AuthCodeURL(redirectURI, "state", "nonce", "verifier")
+	if err == nil {
+		t.Errorf("want an error; got nil")
+	}
+}
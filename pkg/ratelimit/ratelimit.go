@@ -0,0 +1,111 @@
+// Package ratelimit limits how often a client (for example, an IP address or a
+// user) may do something. Every key has a token bucket: a request takes a token,
+// and the tokens are added back at a steady rate, up to the size of the bucket,
+// so short bursts are allowed but the average rate is capped.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit is the size of the bucket and the rate at which it's refilled.
+type Limit struct {
+	// Burst is the size of the bucket: how many requests can be made at once.
+	Burst int
+	// Interval is how often a token is added to the bucket.
+	Interval time.Duration
+}
+
+// Every returns the limit of n requests per the given period, all of which can
+// be made at once.
+func Every(n int, period time.Duration) Limit {
+	return Limit{Burst: n, Interval: period / time.Duration(n)}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps the buckets in memory. A bucket which has been refilled up to the
+// top is no different from a new one, so such buckets are evicted and the map
+// doesn't grow without bounds when a client cycles through many IP addresses.
+type Limiter struct {
+	Limit Limit
+	// Now returns the current time. It can be replaced in tests.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New returns a Limiter which applies the limit to every key separately.
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		Limit:   limit,
+		Now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty, the
+// request isn't allowed, and Allow returns how long the client has to wait for
+// the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.Limit.Interval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Len returns the number of the keys whose buckets aren't full.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// refill returns the number of tokens in the bucket at the given time.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(l.Limit.Interval)
+	if burst := float64(l.Limit.Burst); tokens > burst {
+		return burst
+	}
+	return tokens
+}
+
+// sweep evicts the full buckets. It runs at most once per the time it takes to
+// refill an empty bucket, so the cost is spread over many calls. The caller must
+// hold the mutex.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.fillTime() {
+		return
+	}
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.Limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *Limiter) fillTime() time.Duration {
+	return time.Duration(l.Limit.Burst) * l.Limit.Interval
+}
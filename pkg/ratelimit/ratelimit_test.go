@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// Define a fakeClock type, which lets the tests move the time forward.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2021, 12, 18, 10, 0, 0, 0, time.UTC)}
+	l := New(Every(3, time.Minute))
+	l.Now = clock.Now
+	return l, clock
+}
+
+func TestEvery(t *testing.T) {
+	got := Every(10, time.Minute)
+	want := Limit{Burst: 10, Interval: 6 * time.Second}
+	if got != want {
+		t.Errorf("want %+v; got %+v", want, got)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	l, clock := newTestLimiter()
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		key       string
+		wantOK    bool
+		wantRetry time.Duration
+	}{
+		{"First request", 0, "ip:1", true, 0},
+		{"Second request", 0, "ip:1", true, 0},
+		{"Third request", 0, "ip:1", true, 0},
+		{"Bucket is empty", 0, "ip:1", false, 20 * time.Second},
+		{"Another key", 0, "ip:2", true, 0},
+		{"Partly refilled", 15 * time.Second, "ip:1", false, 5 * time.Second},
+		{"One token", 5 * time.Second, "ip:1", true, 0},
+		{"Empty again", 0, "ip:1", false, 20 * time.Second},
+		{"Full after a long pause", time.Hour, "ip:1", true, 0},
+		{"Burst isn't exceeded", 0, "ip:1", true, 0},
+		{"Burst isn't exceeded", 0, "ip:1", true, 0},
+		{"Burst is used up", 0, "ip:1", false, 20 * time.Second},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		ok, retry := l.Allow(step.key)
+		if ok != step.wantOK || retry != step.wantRetry {
+			t.Errorf("%s: want %v, %v; got %v, %v", step.name, step.wantOK, step.wantRetry, ok, retry)
+		}
+	}
+}
+
+func TestEviction(t *testing.T) {
+	l, clock := newTestLimiter()
+
+	l.Allow("ip:1")
+	clock.Advance(30 * time.Second)
+	l.Allow("ip:2")
+	l.Allow("ip:2")
+	l.Allow("ip:2")
+	if l.Len() != 2 {
+		t.Fatalf("want 2 keys; got %d", l.Len())
+	}
+
+	// A minute later the bucket of ip:1 is full again, but ip:2 is still short of tokens.
+	clock.Advance(30 * time.Second)
+	l.Allow("ip:3")
+	if l.Len() != 2 {
+		t.Errorf("want ip:1 evicted; got %d keys", l.Len())
+	}
+
+	clock.Advance(time.Hour)
+	l.Allow("ip:4")
+	if l.Len() != 1 {
+		t.Errorf("want only ip:4 kept; got %d keys", l.Len())
+	}
+}
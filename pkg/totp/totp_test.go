@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The secret from the test vectors in appendix B of RFC 6238 (SHA1 variant).
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 lists 8-digit codes; our 6-digit codes are their last 6 digits.
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{"59", 59, "287082"},
+		{"1111111109", 1111111109, "081804"},
+		{"1111111111", 1111111111, "050471"},
+		{"1234567890", 1234567890, "005924"},
+		{"2000000000", 2000000000, "279037"},
+		{"20000000000", 20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.want {
+				t.Errorf("want %q; got %q", tt.want, code)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	codeAt := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"Current step", codeAt(current), current, true},
+		{"Previous step", codeAt(current - 1), current - 1, true},
+		{"Next step", codeAt(current + 1), current + 1, true},
+		{"Too old", codeAt(current - 2), 0, false},
+		{"With spaces", codeAt(current)[:3] + " " + codeAt(current)[3:], current, true},
+		{"Wrong length", "12345", 0, false},
+		{"Empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("want %d, %t; got %d, %t", tt.wantStep, tt.wantOK, step, ok)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Snippetbox", "alice@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Snippetbox:alice@example.com?") {
+		t.Errorf("unexpected URI prefix: %q", uri)
+	}
+	for _, param := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Snippetbox", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("want URI %q to contain %q", uri, param)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Package tracing implements a small subset of distributed tracing: spans with
+// attributes, which are linked into traces through the context, the W3C Trace
+// Context headers (traceparent and tracestate) and an exporter which writes the
+// finished spans as JSON lines, for example to the standard output or a file.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace: all the spans of one request, across services.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID isn't all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID isn't all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span which is propagated to other services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// The W3C Trace Context headers.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// ParseTraceparent parses the value of the traceparent header, such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", s)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff {
+		return sc, fmt.Errorf("tracing: unsupported traceparent version %q", parts[0])
+	}
+	// Version 00 has exactly four fields; later versions may add more at the end.
+	if version[0] == 0 && len(parts) != 4 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", s)
+	}
+	if !decodeHex(sc.TraceID[:], parts[1]) || !sc.TraceID.IsValid() {
+		return sc, fmt.Errorf("tracing: invalid trace ID %q", parts[1])
+	}
+	if !decodeHex(sc.SpanID[:], parts[2]) || !sc.SpanID.IsValid() {
+		return sc, fmt.Errorf("tracing: invalid parent ID %q", parts[2])
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return sc, fmt.Errorf("tracing: invalid trace flags %q", parts[3])
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex decodes s into dst, which must be filled exactly. Only lowercase
+// hex digits are allowed by the specification.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Traceparent formats the span context as the value of the traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Extract returns the span context from the headers of an incoming request. The
+// result is invalid if there is no (valid) traceparent header.
+func Extract(h http.Header) SpanContext {
+	sc, err := ParseTraceparent(strings.TrimSpace(h.Get(TraceparentHeader)))
+	if err != nil {
+		return SpanContext{}
+	}
+	sc.TraceState = strings.Join(h.Values(TracestateHeader), ",")
+	return sc
+}
+
+// Inject sets the headers of an outgoing request, so that the spans of the other
+// service become children of the span in ctx.
+func Inject(ctx context.Context, h http.Header) {
+	span := FromContext(ctx)
+	if span == nil {
+		return
+	}
+	h.Set(TraceparentHeader, span.sc.Traceparent())
+	if span.sc.TraceState != "" {
+		h.Set(TracestateHeader, span.sc.TraceState)
+	}
+}
+
+// SpanData is a finished span, as it's passed to the exporter.
+type SpanData struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Exporter sends the finished spans somewhere. Export is called from many goroutines.
+type Exporter interface {
+	Export(*SpanData)
+}
+
+// WriterExporter writes every span as a JSON object on its own line.
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter returns an exporter which writes to w, such as os.Stdout or a file.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+// Export writes the span.
+func (e *WriterExporter) Export(s *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(s)
+}
+
+// Tracer starts the spans and passes the sampled ones to the exporter when they end.
+type Tracer struct {
+	exporter Exporter
+
+	// Now returns the current time. The tests replace it with a fake clock.
+	Now func() time.Time
+}
+
+// New returns a Tracer which exports the spans to e.
+func New(e Exporter) *Tracer {
+	return &Tracer{exporter: e, Now: time.Now}
+}
+
+// Span is an operation within a trace, such as a request or a database query.
+// The methods of a nil *Span do nothing, so that the code doesn't have to check
+// whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+type contextKey struct{}
+
+// FromContext returns the current span, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+// Start starts a span, which is a child of the span in ctx, and returns the span
+// and a context with it. Without a span in ctx, it starts a new trace.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	var parent SpanContext
+	if span := FromContext(ctx); span != nil {
+		parent = span.sc
+	}
+	return t.start(ctx, name, parent)
+}
+
+// StartRemote starts a span which is a child of the span of another service, such
+// as the one in the traceparent header of a request (see Extract). If the remote
+// span context is invalid, it starts a new trace.
+func (t *Tracer) StartRemote(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	return t.start(ctx, name, remote)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	span := &Span{tracer: t, name: name, start: t.Now()}
+	if parent.IsValid() {
+		// The decision of the caller to record the trace is kept for the whole trace.
+		span.sc = parent
+		span.parent = parent.SpanID
+	} else {
+		span.sc = SpanContext{TraceID: newTraceID(), Sampled: true}
+	}
+	span.sc.SpanID = newSpanID()
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+// Context returns the span context, which identifies the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// Set sets an attribute of the span, such as the route of a request.
+func (s *Span) Set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = value
+}
+
+// SetName replaces the name of the span, for example with the route of a request,
+// which is only known after routing.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes the span and exports it if the trace is sampled. Only the first
+// call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := s.tracer.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := &SpanData{
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Name:       s.name,
+		Start:      s.start,
+		End:        end,
+		Duration:   float64(end.Sub(s.start).Microseconds()) / 1000,
+		Attributes: s.attrs,
+		Status:     "ok",
+	}
+	if s.err != nil {
+		data.Status = "error"
+		data.Error = s.err.Error()
+	}
+	s.mu.Unlock()
+
+	if s.parent.IsValid() {
+		data.ParentID = s.parent.String()
+	}
+	if s.sc.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
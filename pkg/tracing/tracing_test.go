@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Define a recorder type, which keeps the exported spans.
+type recorder struct {
+	spans []*SpanData
+}
+
+func (r *recorder) Export(s *SpanData) {
+	r.spans = append(r.spans, s)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantErr     bool
+		wantSampled bool
+	}{
+		{"Sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"Not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"Future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, true},
+		{"Extra field in version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, false},
+		{"Invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"Zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true, false},
+		{"Zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true, false},
+		{"Uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true, false},
+		{"Short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", true, false},
+		{"Garbage", "hello", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.traceparent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("want trace ID %q; got %q", "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID)
+			}
+			if sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("want span ID %q; got %q", "00f067aa0ba902b7", sc.SpanID)
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("want sampled %v; got %v", tt.wantSampled, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	rec := &recorder{}
+	tracer := New(rec)
+
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Set("tracestate", "vendor=abc")
+
+	ctx, span := tracer.StartRemote(context.Background(), "request", Extract(in))
+	_, child := tracer.Start(ctx, "query")
+
+	out := http.Header{}
+	Inject(ctx, out)
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.Context().SpanID.String() + "-01"
+	if got := out.Get("traceparent"); got != want {
+		t.Errorf("want traceparent %q; got %q", want, got)
+	}
+	if got := out.Get("tracestate"); got != "vendor=abc" {
+		t.Errorf("want tracestate %q; got %q", "vendor=abc", got)
+	}
+
+	child.End()
+	span.End()
+	if len(rec.spans) != 2 {
+		t.Fatalf("want 2 spans; got %d", len(rec.spans))
+	}
+	if rec.spans[0].ParentID != span.Context().SpanID.String() {
+		t.Errorf("want the query to be a child of the request; got parent %q", rec.spans[0].ParentID)
+	}
+	if rec.spans[1].ParentID != "00f067aa0ba902b7" {
+		t.Errorf("want the request to be a child of the remote span; got parent %q", rec.spans[1].ParentID)
+	}
+	for _, s := range rec.spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("want the trace ID of the caller; got %q", s.TraceID)
+		}
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	rec := &recorder{}
+	tracer := New(rec)
+
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.StartRemote(context.Background(), "request", Extract(in))
+	span.End()
+
+	if len(rec.spans) != 0 {
+		t.Errorf("want no exported spans; got %d", len(rec.spans))
+	}
+}
+
+func TestNewTrace(t *testing.T) {
+	rec := &recorder{}
+	tracer := New(rec)
+
+	_, span := tracer.StartRemote(context.Background(), "request", Extract(http.Header{}))
+	span.End()
+	span.End()
+
+	if len(rec.spans) != 1 {
+		t.Fatalf("want 1 span; got %d", len(rec.spans))
+	}
+	if rec.spans[0].ParentID != "" {
+		t.Errorf("want a root span; got parent %q", rec.spans[0].ParentID)
+	}
+	if !span.Context().IsValid() || !span.Context().Sampled {
+		t.Errorf("want a valid sampled span context; got %+v", span.Context())
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(NewWriterExporter(&buf))
+	start := time.Date(2021, 12, 18, 10, 0, 0, 0, time.UTC)
+	now := start
+	tracer.Now = func() time.Time { return now }
+
+	_, span := tracer.Start(context.Background(), "query")
+	span.SetName("SnippetModel.Get")
+	span.Set("db.system", "mysql")
+	span.SetError(errors.New("no rows"))
+	now = now.Add(1500 * time.Microsecond)
+	span.End()
+
+	var got SpanData
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "SnippetModel.Get" {
+		t.Errorf("want name %q; got %q", "SnippetModel.Get", got.Name)
+	}
+	if got.Duration != 1.5 {
+		t.Errorf("want duration %v; got %v", 1.5, got.Duration)
+	}
+	if got.Status != "error" || got.Error != "no rows" {
+		t.Errorf("want status error with %q; got %q with %q", "no rows", got.Status, got.Error)
+	}
+	if got.Attributes["db.system"] != "mysql" {
+		t.Errorf("want attribute db.system=mysql; got %v", got.Attributes)
+	}
+	if !got.Start.Equal(start) {
+		t.Errorf("want start %v; got %v", start, got.Start)
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	var span *Span
+	span.Set("key", "value")
+	span.SetError(errors.New("error"))
+	span.End()
+
+	h := http.Header{}
+	Inject(context.Background(), h)
+	if len(h) != 0 {
+		t.Errorf("want no headers; got %v", h)
+	}
+}
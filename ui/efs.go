@@ -0,0 +1,10 @@
+package ui
+
+import "embed"
+
+// Files holds the HTML templates and the static files of the web application.
+// They are embedded into the binary, so that it can be deployed on its own,
+// whatever the working directory is.
+//
+//go:embed "html" "static"
+var Files embed.FS